@@ -0,0 +1,33 @@
+// Command lokalstack-reap removes localstack containers left behind by crashed
+// or killed test runs
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kraneware/lokalstack"
+)
+
+func main() {
+	ttl := flag.Duration("ttl", 0, "also remove containers older than this duration")
+	idle := flag.Duration("idle", 30*time.Minute, "remove reusable containers unused for this duration, 0 to keep them")
+	flag.Parse()
+
+	removed, err := lokalstack.Reap(context.Background(), *ttl)
+	if err == nil && *idle > 0 {
+		var idleRemoved []string
+		idleRemoved, err = lokalstack.ReapIdle(context.Background(), *idle)
+		removed = append(removed, idleRemoved...)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Removed %d localstack containers\n", len(removed))
+}
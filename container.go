@@ -2,112 +2,222 @@ package lokalstack
 
 import (
 	"context"
-	"fmt"
 	"github.com/aws/aws-sdk-go/service/apigateway"
-	"github.com/kraneware/kws/config"
-	"github.com/kraneware/kws/services"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/aws/aws-xray-sdk-go/xray"
 
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/ory/dockertest"
 	"github.com/pkg/errors"
 
 	dc "github.com/ory/dockertest/docker"
 )
 
-var resource *dockertest.Resource // nolint:gochecknoglobals
-var resourcePool *dockertest.Pool // nolint:gochecknoglobals
+// defaultStack backs the package level StartContainer and StopContainer
+var defaultStack *Stack // nolint:gochecknoglobals
 
 const (
 	GenericEmptyLambda = "generic_empty_lambda"
 	TestRegion         = endpoints.UsEast1RegionID
 )
 
-func xrayInit() (err error) { // nolint:gochecknoinits
-	if config.Endpoints.XRay != "" {
-		fmt.Println("Configuring test xray context missing strategy")
+func (s *Stack) xrayInit() (err error) { // nolint:gochecknoinits
+	if s.Endpoints.XRay != "" {
+		s.log(DebugLevel, "Configuring test xray context missing strategy")
 		cms := &TestContextMissingStrategy{}
 		err = xray.Configure(xray.Config{ContextMissingStrategy: cms})
 	}
 	return
 }
 
-func dynamoClientReady(ctx context.Context) (err error) {
-	_, err = services.DynamoDbClient().ListTablesWithContext(ctx, &dynamodb.ListTablesInput{})
+const (
+	ServiceDynamoDB   = "dynamodb"
+	ServiceLambda     = "lambda"
+	ServiceSNS        = "sns"
+	ServiceSQS        = "sqs"
+	ServiceS3         = "s3"
+	ServiceAPIGateway = "apigateway"
+)
+
+// Probe checks whether a service of the stack accepts requests
+type Probe func(ctx context.Context, s *Stack) error
+
+var (
+	probes = map[string]Probe{ // nolint:gochecknoglobals
+		ServiceDynamoDB:   dynamoClientReady,
+		ServiceLambda:     lambdaClientReady,
+		ServiceSNS:        snsClientReady,
+		ServiceSQS:        sqsClientReady,
+		ServiceS3:         s3ClientReady,
+		ServiceAPIGateway: apigwClientReady,
+	}
+	probesMu sync.RWMutex // nolint:gochecknoglobals
+)
+
+// RegisterProbe registers the readiness probe of a service, replacing any previous probe
+func RegisterProbe(service string, probe Probe) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	probes[service] = probe
+}
+
+func lookupProbe(service string) (probe Probe, ok bool) {
+	probesMu.RLock()
+	defer probesMu.RUnlock()
+	probe, ok = probes[service]
 	return
 }
 
-func lambdaClientReady(ctx context.Context) (err error) {
-	_, err = services.LambdaClient().ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{
+// DefaultReadyServices returns the services waited on when none are configured
+func DefaultReadyServices() []string {
+	return []string{ServiceDynamoDB, ServiceLambda, ServiceSNS, ServiceSQS, ServiceS3, ServiceAPIGateway}
+}
+
+// ReadinessError reports the services that did not accept requests, by service name
+type ReadinessError struct {
+	Services map[string]error
+}
+
+func (e *ReadinessError) Error() string {
+	names := make([]string, 0, len(e.Services))
+	for name := range e.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, name+": "+e.Services[name].Error())
+	}
+
+	return "services not ready: " + strings.Join(msgs, "; ")
+}
+
+func dynamoClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.DynamoDbClient().ListTablesWithContext(ctx, &dynamodb.ListTablesInput{})
+	return
+}
+
+func lambdaClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.LambdaClient().ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{
 		Marker:   aws.String(""),
 		MaxItems: aws.Int64(128),
 	})
 	return err
 }
 
-func snsClientReady(ctx context.Context) (err error) {
-	_, err = services.SNSClient().ListTopicsWithContext(ctx, &sns.ListTopicsInput{})
+func snsClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.SNSClient().ListTopicsWithContext(ctx, &sns.ListTopicsInput{})
 	return err
 }
 
-func sqsClientReady(ctx context.Context) (err error) {
-	_, err = services.SQSClient().ListQueuesWithContext(ctx, &sqs.ListQueuesInput{})
+func sqsClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.SQSClient().ListQueuesWithContext(ctx, &sqs.ListQueuesInput{})
 	return err
 }
 
-func s3ClientReady(ctx context.Context) (err error) {
-	_, err = services.S3Client().ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+func s3ClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.S3Client().ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
 	return err
 }
 
-func apigwClientReady(ctx context.Context) (err error) {
-	_, err = services.APIGWClient().GetDomainNamesWithContext(ctx, &apigateway.GetDomainNamesInput{})
-	return nil
+func apigwClientReady(ctx context.Context, s *Stack) (err error) {
+	_, err = s.APIGWClient().GetDomainNamesWithContext(ctx, &apigateway.GetDomainNamesInput{})
+	return err
+}
+
+// readyServices returns the services to wait on, the ones passed to WithReadyServices,
+// else the ones passed to WithServices, else DefaultReadyServices
+func (s *Stack) readyServices() (res []string) {
+	services := s.opts.ReadyServices
+	if len(services) == 0 {
+		services = s.opts.Services
+	}
+	if len(services) == 0 {
+		services = DefaultReadyServices()
+	}
+
+	for _, service := range services {
+		if _, ok := lookupProbe(service); ok {
+			res = append(res, service)
+		} else {
+			s.log(WarnLevel, "No readiness probe registered, not waiting on service", F("service", service))
+		}
+	}
+
+	return res
+}
+
+// waitsOn reports whether startup waits on the given service
+func (s *Stack) waitsOn(service string) bool {
+	for _, v := range s.services {
+		if v == service {
+			return true
+		}
+	}
+	return false
 }
 
-func checkContainerReady() (err error) {
-	fmt.Println(fmt.Sprintf("Checking if container is ready with aws region: %s ...", config.Region))
-	err = xrayInit()
+// checkContainerReady probes every service startup waits on until it is ready or
+// the readiness timeout expires, and records the attempts in the startup report
+func (s *Stack) checkContainerReady(ctx context.Context) (err error) {
+	s.log(InfoLevel, "Checking if container is ready", F("region", s.Region), F("services", s.services))
+	err = s.xrayInit()
 	if err == nil {
 		testCtx, td := NewTestDaemon()
 		defer td.Close()
 
-		fmt.Println("Initialized test xray")
-		var errGroup errgroup.Group
+		s.log(DebugLevel, "Initialized test xray")
+
+		readyCtx, cancel := context.WithTimeout(testCtx, s.opts.ReadyTimeout)
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-readyCtx.Done():
+			}
+		}()
+
+		start := time.Now()
+		s.report = &StartupReport{Services: make([]ServiceReport, len(s.services))}
 
 		// Test availability of services
-		errGroup.Go(func() error {
-			return dynamoClientReady(testCtx)
-		})
-		errGroup.Go(func() error {
-			return lambdaClientReady(testCtx)
-		})
-		errGroup.Go(func() error {
-			return snsClientReady(testCtx)
-		})
-		errGroup.Go(func() error {
-			return sqsClientReady(testCtx)
-		})
-		errGroup.Go(func() error {
-			return s3ClientReady(testCtx)
-		})
-		errGroup.Go(func() error {
-			return apigwClientReady(testCtx)
-		})
-
-		err = errGroup.Wait()
+		var wg sync.WaitGroup
+		for i, service := range s.services {
+			probe, _ := lookupProbe(service)
+			s.report.Services[i].Service = service
+
+			wg.Add(1)
+			go func(sr *ServiceReport, probe Probe) {
+				defer wg.Done()
+				s.waitService(readyCtx, sr, probe, start)
+			}(&s.report.Services[i], probe)
+		}
+		wg.Wait()
+
+		s.report.Duration = time.Since(start)
+		if failed := s.report.failed(); len(failed) > 0 {
+			err = &StartupError{
+				Report: s.report,
+				Logs:   s.tailLogs(200),
+				Err:    &ReadinessError{Services: failed},
+			}
+		}
 	}
 
 	return err
@@ -122,8 +232,9 @@ func createPortBindings(ports ...string) (res map[dc.Port][]dc.PortBinding) {
 	return
 }
 
-func DefaultPortBindings() (res map[dc.Port][]dc.PortBinding) {
-	res = createPortBindings(
+// localstackPorts lists the container ports published by localstack
+func localstackPorts() []string {
+	return []string{
 		"4566", // Universal port
 		"4572", // S3
 		"4569", // Dynamodb
@@ -136,7 +247,19 @@ func DefaultPortBindings() (res map[dc.Port][]dc.PortBinding) {
 		"4594", // RDS
 		"4583", // SSM
 		"4597", //EC2
-	)
+	}
+}
+
+// exposedPorts converts port numbers to exposed container ports without host bindings
+func exposedPorts(ports ...string) (res []string) {
+	for _, port := range ports {
+		res = append(res, port+"/tcp")
+	}
+	return
+}
+
+func DefaultPortBindings() (res map[dc.Port][]dc.PortBinding) {
+	res = createPortBindings(localstackPorts()...)
 	return
 }
 
@@ -146,62 +269,20 @@ func GetDefaultLocalstackCredentials() *credentials.Credentials {
 	return credentials.NewStaticCredentials("foo", "bar", "")
 }
 
-// StartContainer starts a localstack container for testing purposes and builds out needed infrastructure
-func StartContainer() (err error) { // nolint:funlen
-	fmt.Println("Starting localstack container ... ")
-
-	if resourcePool == nil {
-		resourcePool, err = dockertest.NewPool("")
+// StartContainer starts a localstack container for testing purposes and builds out needed infrastructure.
+// The container can be configured through the LOKALSTACK_* environment variables,
+// setting LOKALSTACK_ENDPOINT attaches to a running localstack instead.
+func StartContainer() (err error) {
+	var s *Stack
+	if endpoint := os.Getenv(EnvEndpoint); endpoint != "" {
+		s, err = Attach(context.Background(), endpoint, FromEnvironment())
+	} else {
+		s, err = Start(context.Background(), FromEnvironment())
 	}
 
 	if err == nil {
-		// Starting localstack docker container with port mappings
-		// Lambdas in golang require 'LAMBDA_EXECUTOR=docker'
-		// Privileged access is required to start docker inside the container
-		resource, err = resourcePool.RunWithOptions(
-			&dockertest.RunOptions{
-				Repository:   "localstack/localstack",
-				Tag:          "0.11.3", //"0.14.1",
-				PortBindings: DefaultPortBindings(),
-				// Env should be []string{} for python lambdas
-				// should be []string{"LAMBDA_EXECUTOR=docker"}, for non-python lambdas
-				// Using python for test downstream lambdas
-				Env: []string{
-					"DEBUG=1",
-					"LOCALSTACK_API_KEY=" + os.Getenv("LOCALSTACK_API_KEY"), // Will fail if Pro is not activated. Lambdas only available in Pro
-					"EXTERNAL_SERVICE_PORTS_START=4510",
-					"EXTERNAL_SERVICE_PORTS_END=4597",
-				},
-				Privileged: true,
-			},
-		)
-
-		if err == nil {
-			fmt.Println("Setting localstack region: " + TestRegion)
-			config.Region = TestRegion
-			config.Credentials = GetDefaultLocalstackCredentials()
-			config.Endpoints = config.AwsEndpointSet{
-				DynamoDB:       "http://localhost:4569",
-				Lambda:         "http://localhost:4574",
-				S3:             "http://localhost:4572",
-				SNS:            "http://localhost:4575",
-				SQS:            "http://localhost:4576",
-				CloudWatch:     "http://localhost:4582",
-				CloudWatchLogs: "http://localhost:4586",
-				XRay:           "http://localhost:4603",
-				RDS:            "http://localhost:4594",
-				SSM:            "http://localhost:4583",
-				APIGateway:     "http://localhost:4566",
-				EC2:            "http://localhost:4597",
-			}
-
-			// Ensuring container is ready to accept requests
-			if err = resourcePool.Retry(checkContainerReady); err == nil {
-				fmt.Println("Started localstack container ... ")
-
-				err = buildTestingInfrastructure()
-			}
-		}
+		s.Use()
+		defaultStack = s
 	}
 
 	return err
@@ -209,34 +290,28 @@ func StartContainer() (err error) { // nolint:funlen
 
 // StopContainer stops the localstack container used for testing
 func StopContainer() (err error) {
-	if resource == nil {
+	if defaultStack == nil {
 		err = errors.New("Container not started")
-	} else {
-		fmt.Println("Stopping localstack container ... ")
-		// Once tests are done, kill and remove the container
-		if err = resourcePool.Retry(func() error {
-			err := resourcePool.Purge(resource)
-			if err != nil {
-				return errors.New("could not stop localstack container")
-			}
-			return err
-		}); err == nil {
-			resource = nil
-			fmt.Println("Stopped localstack container")
-		}
+	} else if err = defaultStack.Close(); err == nil {
+		defaultStack = nil
 	}
 
 	return err
 }
 
-func createGenericLambda() error {
-	testCtx, td := NewTestDaemon()
-	defer td.Close()
-	return NewLambda(testCtx, GenericEmptyLambda, "return {}")
-}
+func (s *Stack) buildTestingInfrastructure(ctx context.Context) (err error) {
+	if !s.waitsOn(ServiceLambda) {
+		return nil
+	}
 
-func buildTestingInfrastructure() (err error) {
-	fmt.Println("Initializing base testing infrastructure ... ")
+	s.log(InfoLevel, "Initializing base testing infrastructure")
 
-	return createGenericLambda()
+	err = newLambda(ctx, s.LambdaClient(), GenericEmptyLambda, NewPythonModule().Body("return {}").Source())
+
+	// An attached localstack may already hold the infrastructure from an earlier run
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == lambda.ErrCodeResourceConflictException {
+		err = nil
+	}
+
+	return err
 }
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net"
 	"sync"
 	"time"
@@ -132,7 +131,7 @@ func (tss *TestSamplingStrategy) ShouldTrace(request *sampling.Request) *samplin
 }
 
 func (cms *TestContextMissingStrategy) ContextMissing(v interface{}) {
-	fmt.Printf("Test ContextMissing Strategy %v\n", v)
+	logWarn("Test ContextMissing Strategy", F("value", v))
 }
 
 func (sms *TestStreamingStrategy) RequiresStreaming(seg *xray.Segment) bool {
@@ -0,0 +1,247 @@
+package lokalstack
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	golambda "github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/google/uuid"
+	"github.com/kraneware/kws/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// invocationsPrefix prefixes the path of the Invoke API
+	invocationsPrefix = "/2015-03-31/functions/"
+	// invocationsSuffix ends the path of the Invoke API
+	invocationsSuffix = "/invocations"
+	// emulatorAccount is the account id used in the function ARNs of the emulator
+	emulatorAccount = "000000000000"
+)
+
+// LambdaEmulator serves the Lambda Invoke API on a local port for Go handlers
+// running in process, so that Go lambdas can be invoked without a container
+type LambdaEmulator struct {
+	// Endpoint is the URL of the Invoke API, e.g. http://127.0.0.1:43127
+	Endpoint string
+
+	listener  net.Listener
+	server    *http.Server
+	functions map[string]*emulatedFunction
+	mu        sync.RWMutex
+	async     sync.WaitGroup
+	previous  *string
+}
+
+type emulatedFunction struct {
+	name    string
+	handler golambda.Handler
+	timeout time.Duration
+}
+
+// NewLambdaEmulator starts serving the Invoke API on a free port of the loopback interface
+func NewLambdaEmulator() (e *LambdaEmulator, err error) {
+	e = &LambdaEmulator{functions: map[string]*emulatedFunction{}}
+
+	if e.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, errors.Wrap(err, "starting lambda emulator")
+	}
+
+	e.Endpoint = "http://" + e.listener.Addr().String()
+	e.server = &http.Server{Handler: http.HandlerFunc(e.serveInvoke)}
+	go func() {
+		_ = e.server.Serve(e.listener)
+	}()
+
+	logInfo("Started lambda emulator", F("endpoint", e.Endpoint))
+
+	return e, nil
+}
+
+// Register hosts the handler as the named function, replacing any previous handler.
+// The handler may have any signature accepted by lambda.Start of aws-lambda-go.
+func (e *LambdaEmulator) Register(functionName string, handler interface{}) {
+	e.RegisterWithTimeout(functionName, handler, DefaultLambdaTimeout*time.Second)
+}
+
+// RegisterWithTimeout hosts the handler as the named function, the context passed
+// to the handler expires after the timeout
+func (e *LambdaEmulator) RegisterWithTimeout(functionName string, handler interface{}, timeout time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.functions[functionName] = &emulatedFunction{
+		name:    functionName,
+		handler: golambda.NewHandler(handler),
+		timeout: timeout,
+	}
+}
+
+// Use points the kws Lambda endpoint at the emulator, so that services.LambdaClient
+// and the invocation helpers reach it. Close restores the previous endpoint.
+func (e *LambdaEmulator) Use() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.previous == nil {
+		previous := config.Endpoints.Lambda
+		e.previous = &previous
+	}
+	config.Endpoints.Lambda = e.Endpoint
+}
+
+// Close stops serving the Invoke API once the pending asynchronous invocations are done
+func (e *LambdaEmulator) Close() (err error) {
+	e.mu.Lock()
+	if e.previous != nil {
+		config.Endpoints.Lambda = *e.previous
+		e.previous = nil
+	}
+	e.mu.Unlock()
+
+	err = e.server.Close()
+	e.async.Wait()
+
+	logInfo("Stopped lambda emulator", F("endpoint", e.Endpoint))
+
+	return err
+}
+
+// function returns the function addressed by a name, an ARN or a qualified name
+func (e *LambdaEmulator) function(name string) (*emulatedFunction, bool) {
+	if i := strings.Index(name, ":function:"); i >= 0 {
+		name = name[i+len(":function:"):]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	fn, ok := e.functions[name]
+	return fn, ok
+}
+
+func (e *LambdaEmulator) serveInvoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost ||
+		!strings.HasPrefix(r.URL.Path, invocationsPrefix) ||
+		!strings.HasSuffix(r.URL.Path, invocationsSuffix) {
+		writeAPIError(w, http.StatusNotFound, "UnknownOperationException", "unsupported request "+r.Method+" "+r.URL.Path)
+		return
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, invocationsPrefix), invocationsSuffix)
+	fn, ok := e.function(name)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, lambda.ErrCodeResourceNotFoundException, "Function not found: "+name)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, lambda.ErrCodeInvalidRequestContentException, err.Error())
+		return
+	}
+
+	var clientContext lambdacontext.ClientContext
+	if cc := r.Header.Get("X-Amz-Client-Context"); cc != "" {
+		if raw, err := base64.StdEncoding.DecodeString(cc); err == nil {
+			_ = json.Unmarshal(raw, &clientContext)
+		}
+	}
+
+	w.Header().Set("X-Amz-Executed-Version", "$LATEST")
+
+	switch r.Header.Get("X-Amz-Invocation-Type") {
+	case lambda.InvocationTypeDryRun:
+		w.WriteHeader(http.StatusNoContent)
+	case lambda.InvocationTypeEvent:
+		e.async.Add(1)
+		go func() {
+			defer e.async.Done()
+			fn.invoke(payload, clientContext)
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		res := fn.invoke(payload, clientContext)
+		if r.Header.Get("X-Amz-Log-Type") == lambda.LogTypeTail {
+			w.Header().Set("X-Amz-Log-Result", base64.StdEncoding.EncodeToString([]byte(res.logs)))
+		}
+		if res.err != nil {
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(res.payload)
+	}
+}
+
+type emulatedResult struct {
+	payload []byte
+	err     *LambdaError
+	logs    string
+}
+
+// invoke runs the handler with a lambda context, turning returned errors and panics into error payloads
+func (fn *emulatedFunction) invoke(payload []byte, clientContext lambdacontext.ClientContext) (res emulatedResult) {
+	requestID := uuid.New().String()
+	ctx, cancel := context.WithTimeout(context.Background(), fn.timeout)
+	defer cancel()
+	ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+		AwsRequestID:       requestID,
+		InvokedFunctionArn: fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", currentRegion(), emulatorAccount, fn.name),
+		ClientContext:      clientContext,
+	})
+
+	start := time.Now()
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				res.err = &LambdaError{ErrorMessage: fmt.Sprint(v), ErrorType: errorType(v)}
+			}
+		}()
+
+		var err error
+		if res.payload, err = fn.handler.Invoke(ctx, payload); err != nil {
+			res.err = &LambdaError{ErrorMessage: err.Error(), ErrorType: errorType(err)}
+		}
+	}()
+	duration := time.Since(start)
+
+	if res.err != nil {
+		res.payload, _ = json.Marshal(res.err)
+	}
+	res.logs = fmt.Sprintf("START RequestId: %s Version: $LATEST\nEND RequestId: %s\nREPORT RequestId: %s\tDuration: %.2f ms\n",
+		requestID, requestID, requestID, float64(duration)/float64(time.Millisecond))
+
+	logInfo("Invoked emulated lambda", F("function", fn.name), F("duration", duration), F("failed", res.err != nil))
+
+	return res
+}
+
+// errorType names the type of an error or panic value the way the Go runtime reports it
+func errorType(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// writeAPIError writes an error response of the Lambda API, as read by the AWS SDK
+func writeAPIError(w http.ResponseWriter, status int, code string, message string) {
+	body, _ := json.Marshal(map[string]string{"Type": "User", "message": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,89 @@
+package lokalstack
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/kraneware/kws/config"
+)
+
+// EndpointMode selects how the services are reached inside the container
+type EndpointMode int
+
+const (
+	// AutoEndpoints picks legacy or edge endpoints from the image tag
+	AutoEndpoints EndpointMode = iota
+	// LegacyEndpoints reaches each service on its own port, as localstack before 0.12 did
+	LegacyEndpoints
+	// EdgeEndpoints routes every service through the single edge port
+	EdgeEndpoints
+)
+
+// EdgePort is the localstack port routing requests to every service
+const EdgePort = "4566"
+
+// tagVersion matches the major and minor version at the start of an image tag
+var tagVersion = regexp.MustCompile(`^v?(\d+)\.(\d+)`) // nolint:gochecknoglobals
+
+// resolveEndpointMode returns the endpoint mode to use for the given image tag.
+// Localstack removed the per service ports in 0.12, unknown tags such as
+// "latest" are assumed to be recent.
+func resolveEndpointMode(mode EndpointMode, tag string) EndpointMode {
+	if mode != AutoEndpoints {
+		return mode
+	}
+
+	if m := tagVersion.FindStringSubmatch(tag); m != nil {
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		if major == 0 && minor < 12 {
+			return LegacyEndpoints
+		}
+	}
+
+	return EdgeEndpoints
+}
+
+// containerPorts lists the container ports that need publishing in the given mode
+func containerPorts(mode EndpointMode) []string {
+	if mode == EdgeEndpoints {
+		return []string{EdgePort}
+	}
+	return localstackPorts()
+}
+
+// endpointSet builds the service endpoints, hostEndpoint maps a container port
+// to the endpoint it is reachable on from the host
+func endpointSet(
+	mode EndpointMode,
+	hostEndpoint func(port string) (string, error),
+) (
+	res config.AwsEndpointSet,
+	err error,
+) {
+	ports := map[*string]string{
+		&res.DynamoDB:       "4569",
+		&res.Lambda:         "4574",
+		&res.S3:             "4572",
+		&res.SNS:            "4575",
+		&res.SQS:            "4576",
+		&res.CloudWatch:     "4582",
+		&res.CloudWatchLogs: "4586",
+		&res.XRay:           "4603",
+		&res.RDS:            "4594",
+		&res.SSM:            "4583",
+		&res.APIGateway:     EdgePort,
+		&res.EC2:            "4597",
+	}
+
+	for endpoint, port := range ports {
+		if mode == EdgeEndpoints {
+			port = EdgePort
+		}
+		if *endpoint, err = hostEndpoint(port); err != nil {
+			break
+		}
+	}
+
+	return res, err
+}
@@ -0,0 +1,147 @@
+package lokalstack
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+// eventSourceMappingEnabled is the state of a mapping passing records to its function
+const eventSourceMappingEnabled = "Enabled"
+
+// NewEventSourceMapping makes the source, a queue or stream ARN, trigger the function
+// with batches of up to batchSize records and waits until the mapping is enabled.
+// A batchSize of 0 keeps the default batch size of the source. The starting
+// position, e.g. LATEST or TRIM_HORIZON, is only used by streams.
+func NewEventSourceMapping(
+	ctx context.Context,
+	functionName string,
+	sourceArn string,
+	batchSize int64,
+	startingPosition string,
+) (mappingID string, err error) {
+	logInfo("Creating event source mapping for testing",
+		F("function", functionName), F("source", sourceArn), F("batchSize", batchSize))
+
+	input := &lambda.CreateEventSourceMappingInput{
+		FunctionName:   aws.String(functionName),
+		EventSourceArn: aws.String(sourceArn),
+		Enabled:        aws.Bool(true),
+	}
+	if batchSize > 0 {
+		input.BatchSize = aws.Int64(batchSize)
+	}
+	if startingPosition != "" {
+		input.StartingPosition = aws.String(startingPosition)
+	}
+
+	var output *lambda.EventSourceMappingConfiguration
+	if output, err = services.LambdaClient().CreateEventSourceMappingWithContext(ctx, input); err == nil {
+		mappingID = aws.StringValue(output.UUID)
+		err = WaitForEventSourceMapping(ctx, mappingID)
+	}
+
+	return mappingID, err
+}
+
+// WaitForEventSourceMapping waits until the event source mapping is enabled
+func WaitForEventSourceMapping(ctx context.Context, mappingID string) error {
+	var state string
+
+	err := poll(ctx, func(ctx context.Context) (bool, error) {
+		output, err := services.LambdaClient().GetEventSourceMappingWithContext(ctx, &lambda.GetEventSourceMappingInput{
+			UUID: aws.String(mappingID),
+		})
+		if err == nil {
+			state = aws.StringValue(output.State)
+		}
+		return state == eventSourceMappingEnabled, err
+	})
+
+	return errors.Wrapf(err, "waiting for event source mapping %s to be enabled, last state %q", mappingID, state)
+}
+
+// QueueArn returns the ARN of the queue with the given URL, as returned by NewSQS
+func QueueArn(ctx context.Context, queueURL string) (arn string, err error) {
+	var output *sqs.GetQueueAttributesOutput
+	if output, err = services.SQSClient().GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameQueueArn}),
+	}); err == nil {
+		arn = aws.StringValue(output.Attributes[sqs.QueueAttributeNameQueueArn])
+	}
+
+	return arn, err
+}
+
+// NewSQSEventSource makes the queue with the given URL trigger the function with
+// batches of up to batchSize messages, 0 for the default batch size
+func NewSQSEventSource(
+	ctx context.Context,
+	functionName string,
+	queueURL string,
+	batchSize int64,
+) (mappingID string, err error) {
+	var queueArn string
+	if queueArn, err = QueueArn(ctx, queueURL); err == nil {
+		mappingID, err = NewEventSourceMapping(ctx, functionName, queueArn, batchSize, "")
+	}
+
+	return mappingID, err
+}
+
+// NewDynamoDBStreamEventSource makes the stream of the table trigger the function with
+// batches of up to batchSize records read from the starting position, e.g. TRIM_HORIZON,
+// 0 for the default batch size. The table needs a stream specification.
+func NewDynamoDBStreamEventSource(
+	ctx context.Context,
+	functionName string,
+	tableName string,
+	batchSize int64,
+	startingPosition string,
+) (mappingID string, err error) {
+	var output *dynamodb.DescribeTableOutput
+	if output, err = services.DynamoDbClient().DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}); err == nil {
+		if streamArn := aws.StringValue(output.Table.LatestStreamArn); streamArn != "" {
+			mappingID, err = NewEventSourceMapping(ctx, functionName, streamArn, batchSize, startingPosition)
+		} else {
+			err = errors.Errorf("table %s has no stream", tableName)
+		}
+	}
+
+	return mappingID, err
+}
+
+// SubscribeLambdaToSNS subscribes the function to the topic and returns the subscription ARN
+func SubscribeLambdaToSNS(
+	ctx context.Context,
+	topicArn string,
+	functionName string,
+) (subscriptionArn string, err error) {
+	logInfo("Subscribing lambda function to topic for testing", F("function", functionName), F("topic", topicArn))
+
+	var function *lambda.GetFunctionOutput
+	if function, err = services.LambdaClient().GetFunctionWithContext(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	}); err == nil {
+		var output *sns.SubscribeOutput
+		if output, err = services.SNSClient().SubscribeWithContext(ctx, &sns.SubscribeInput{
+			TopicArn:              aws.String(topicArn),
+			Protocol:              aws.String("lambda"),
+			Endpoint:              function.Configuration.FunctionArn,
+			ReturnSubscriptionArn: aws.Bool(true),
+		}); err == nil {
+			subscriptionArn = aws.StringValue(output.SubscriptionArn)
+		}
+	}
+
+	return subscriptionArn, err
+}
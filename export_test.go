@@ -0,0 +1,40 @@
+package lokalstack
+
+import (
+	"time"
+
+	"github.com/kraneware/kws/config"
+)
+
+// AttachedEndpoints builds the endpoints of a stack attached to the given localstack
+func AttachedEndpoints(endpoint string, mode EndpointMode) (res config.AwsEndpointSet, err error) {
+	s := &Stack{opts: defaultOptions()}
+	if s.attached, err = parseEndpoint(endpoint); err == nil {
+		res, err = endpointSet(mode, s.hostEndpoint)
+	}
+
+	return res, err
+}
+
+// Orphaned reports whether the container with the given labels lost its owner or outlived the ttl
+var Orphaned = orphaned // nolint:gochecknoglobals
+
+// OwnerLabels returns the labels identifying this process as the owner of a container
+var OwnerLabels = ownerLabels // nolint:gochecknoglobals
+
+// LastUsed returns when the reusable container was last used, or started
+var LastUsed = lastUsed // nolint:gochecknoglobals
+
+// TouchLastUsed records that the reusable container is used now
+var TouchLastUsed = touchLastUsed // nolint:gochecknoglobals
+
+// LastUsedPath returns the file recording when the reusable container was last used
+var LastUsedPath = lastUsedPath // nolint:gochecknoglobals
+
+// Next returns the delay following the given one
+func (b Backoff) Next(delay time.Duration) time.Duration {
+	return b.next(delay)
+}
+
+// ReadFixture reads the items of a fixture file
+var ReadFixture = readFixture // nolint:gochecknoglobals
@@ -0,0 +1,300 @@
+package lokalstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// batchWriteLimit is the maximum number of items of a BatchWriteItem request
+const batchWriteLimit = 25
+
+// seedBackoff is the delay policy between the retries of unprocessed items
+var seedBackoff = Backoff{Initial: 100 * time.Millisecond, Max: 2 * time.Second, Multiplier: 2} // nolint:gochecknoglobals
+
+// dynamoDBTypes are the keys of an attribute value in DynamoDB JSON
+var dynamoDBTypes = map[string]bool{ // nolint:gochecknoglobals
+	"S": true, "N": true, "B": true, "BOOL": true, "NULL": true,
+	"M": true, "L": true, "SS": true, "NS": true, "BS": true,
+}
+
+// jsonNumber matches a number as written in JSON, which DynamoDB accepts as is
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`) // nolint:gochecknoglobals
+
+// SeedTable writes the items of a JSON or YAML fixture file to the table. The
+// file holds a list of items, or an object with an Items list as written by
+// aws dynamodb scan. Items may be in DynamoDB JSON, e.g. {"id": {"S": "1"}}, or
+// plain documents, e.g. {"id": "1"}, marshalled with dynamodbattribute.
+func SeedTable(ctx context.Context, tableName string, path string) (err error) {
+	var items []map[string]*dynamodb.AttributeValue
+	if items, err = readFixture(path); err != nil {
+		return errors.Wrapf(err, "reading fixture %s", path)
+	}
+
+	logInfo("Seeding table for testing", F("table", tableName), F("fixture", path), F("items", len(items)))
+
+	return errors.Wrapf(
+		batchWrite(ctx, services.DynamoDbClient(), tableName, items),
+		"seeding table %s from %s", tableName, path,
+	)
+}
+
+// SeedFixtures seeds every JSON or YAML fixture file of a directory, e.g. one
+// directory per test, into the table named after the file, orders.json seeds orders
+func SeedFixtures(ctx context.Context, dir string) (err error) {
+	var paths []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		var matches []string
+		if matches, err = filepath.Glob(filepath.Join(dir, pattern)); err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		tableName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err = SeedTable(ctx, tableName, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFixture reads the items of a fixture file
+func readFixture(path string) (items []map[string]*dynamodb.AttributeValue, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		var node yaml.Node
+		if err = yaml.Unmarshal(contents, &node); err == nil {
+			doc, err = fixtureValue(&node)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.UseNumber()
+		err = decoder.Decode(&doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := doc.(map[string]interface{}); ok {
+		doc = m["Items"]
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.New("fixture is neither a list of items nor an object with an Items list")
+	}
+
+	for i, v := range list {
+		doc, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("item %d is not an object", i)
+		}
+
+		var item map[string]*dynamodb.AttributeValue
+		if isDynamoDBJSON(doc) {
+			var raw []byte
+			if raw, err = json.Marshal(fixtureNumbers(doc, numberString)); err == nil {
+				err = json.Unmarshal(raw, &item)
+			}
+		} else {
+			item, err = dynamodbattribute.MarshalMap(fixtureNumbers(doc, dynamoDBNumber))
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "item %d", i)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// isDynamoDBJSON reports whether every attribute of the item is a DynamoDB JSON attribute value
+func isDynamoDBJSON(item map[string]interface{}) bool {
+	for _, v := range item {
+		value, ok := v.(map[string]interface{})
+		if !ok || len(value) != 1 {
+			return false
+		}
+		for typ := range value {
+			if !dynamoDBTypes[typ] {
+				return false
+			}
+		}
+	}
+	return len(item) > 0
+}
+
+// fixtureValue converts a YAML fixture node the way JSON fixtures are decoded,
+// numbers are kept as json.Number so that they keep their precision
+func fixtureValue(node *yaml.Node) (value interface{}, err error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			return fixtureValue(node.Content[0])
+		}
+	case yaml.AliasNode:
+		return fixtureValue(node.Alias)
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+		case "!!bool":
+			err = node.Decode(&value)
+		case "!!int", "!!float":
+			value, err = fixtureNumber(node)
+		default:
+			value = node.Value
+		}
+	case yaml.SequenceNode:
+		l := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			if l[i], err = fixtureValue(item); err != nil {
+				return nil, err
+			}
+		}
+		value = l
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if m[node.Content[i].Value], err = fixtureValue(node.Content[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		value = m
+	}
+
+	return value, errors.Wrapf(err, "line %d", node.Line)
+}
+
+// fixtureNumber returns a YAML number as written, other notations such as 0x1f
+// or 1_000 are decoded and written as a decimal number
+func fixtureNumber(node *yaml.Node) (json.Number, error) {
+	if jsonNumber.MatchString(node.Value) {
+		return json.Number(node.Value), nil
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			return json.Number(strconv.FormatFloat(v, 'f', -1, 64)), nil
+		}
+	}
+
+	return "", errors.Errorf("%s is not a DynamoDB number", node.Value)
+}
+
+// dynamoDBNumber marshals a number of a plain document as a DynamoDB number
+func dynamoDBNumber(n json.Number) interface{} {
+	return dynamodbattribute.Number(n)
+}
+
+// numberString writes a number of a DynamoDB JSON item as a string, e.g. the
+// unquoted 5 of {N: 5}, as DynamoDB JSON expects
+func numberString(n json.Number) interface{} {
+	return n.String()
+}
+
+// fixtureNumbers converts the JSON numbers of a document with convert, keeping their precision
+func fixtureNumbers(v interface{}, convert func(json.Number) interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return convert(v)
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = fixtureNumbers(value, convert)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = fixtureNumbers(value, convert)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// batchWrite puts the items in batches of 25, retrying the unprocessed items with backoff
+func batchWrite(
+	ctx context.Context,
+	client dynamodbiface.DynamoDBAPI,
+	tableName string,
+	items []map[string]*dynamodb.AttributeValue,
+) (err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultWaitTimeout)
+		defer cancel()
+	}
+
+	for start := 0; start < len(items); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(items) {
+			end = len(items)
+		}
+
+		requests := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, item := range items[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: item},
+			})
+		}
+
+		delay := seedBackoff.Initial
+		for len(requests) > 0 {
+			var output *dynamodb.BatchWriteItemOutput
+			if output, err = client.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{tableName: requests},
+			}); err != nil {
+				return err
+			}
+
+			if requests = output.UnprocessedItems[tableName]; len(requests) > 0 {
+				logWarn("Retrying unprocessed items", F("table", tableName), F("items", len(requests)))
+
+				select {
+				case <-ctx.Done():
+					return errors.Wrapf(ctx.Err(), "%d unprocessed items", len(requests))
+				case <-time.After(delay):
+				}
+				delay = seedBackoff.next(delay)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,20 +1,18 @@
 package lokalstack
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
-	"fmt"
 	"github.com/aws/aws-sdk-go/service/apigateway"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"io"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	"github.com/kraneware/kws/services"
 )
 
@@ -70,7 +68,7 @@ func NewLocalSecondaryIndex(name string, hashAttr string, rangeAttr string) *dyn
 	}
 }
 
-// NewTable creates a new table
+// NewTable creates a new table with a provisioned capacity of 2/2, see TableSpec for other settings
 func NewTable(
 	ctx context.Context,
 	tableName string,
@@ -82,8 +80,6 @@ func NewTable(
 ) (
 	err error,
 ) {
-	fmt.Println("  - Creating " + tableName + " table for testing")
-
 	input := &dynamodb.CreateTableInput{
 		TableName:            aws.String(tableName),
 		AttributeDefinitions: attrDefs,
@@ -101,57 +97,60 @@ func NewTable(
 		input.LocalSecondaryIndexes = local
 	}
 
-	_, err = services.DynamoDbClient().CreateTableWithContext(ctx, input)
-
-	if err == nil && ttlAttr != nil {
-		err = AddTTL(ctx, tableName, *ttlAttr)
-	}
-
-	return err
+	return createTable(ctx, services.DynamoDbClient(), input, ttlAttr)
 }
 
-func newLambdaZip(pythonCode string) (r *bytes.Buffer, err error) {
-	pythonEmptyLambda := fmt.Sprintf(
-		"def handler(event, context):\n"+
-			"  %s\n", pythonCode)
+func newLambdaZip(source string) (r *bytes.Buffer, err error) {
+	var contents []byte
+	contents, err = ZipFiles(map[string][]byte{
+		"handler.py": []byte(source),
+	})
 
-	r = new(bytes.Buffer)
-	writer := zip.NewWriter(r)
-	defer writer.Close()
-
-	var f io.Writer
-	f, err = writer.Create("handler.py")
-	if err == nil {
-		_, err = f.Write([]byte(pythonEmptyLambda))
-	}
-
-	return r, err
+	return bytes.NewBuffer(contents), err
 }
 
-// NewLambda creates a new lambda with the given Python code and deploys to localstack
+// NewLambda creates a new lambda with the given Python code and deploys to localstack.
+// The options may change any setting, the runtime defaults to python3.6. The code
+// is the body of the handler and is indented into it, see NewPythonModule.
 func NewLambda(
 	ctx context.Context,
 	functionName string,
 	pythonCode string,
+	opts ...LambdaOption,
 ) (err error) {
-	fmt.Println("  - Creating " + functionName + " lambda function for testing")
+	source := NewPythonModule().Body(pythonCode).Source()
+	return newLambda(ctx, services.LambdaClient(), functionName, source, opts...)
+}
 
+// NewLegacyLambda creates a new lambda like NewLambda did in earlier versions: only
+// the first line of the code is indented by two spaces, the following lines must
+// already carry that indent, e.g. "x = 1\n  return x"
+func NewLegacyLambda(
+	ctx context.Context,
+	functionName string,
+	pythonCode string,
+	opts ...LambdaOption,
+) (err error) {
+	source := "def handler(event, context):\n  " + pythonCode + "\n"
+	return newLambda(ctx, services.LambdaClient(), functionName, source, opts...)
+}
+
+func newLambda(
+	ctx context.Context,
+	client lambdaiface.LambdaAPI,
+	functionName string,
+	source string,
+	opts ...LambdaOption,
+) (err error) {
 	var zipContents *bytes.Buffer
-	zipContents, err = newLambdaZip(pythonCode)
+	zipContents, err = newLambdaZip(source)
 
 	if err == nil {
-		input := &lambda.CreateFunctionInput{
-			Code: &lambda.FunctionCode{
-				ZipFile: zipContents.Bytes(),
-			},
-			FunctionName: aws.String(functionName),
-			Handler:      aws.String("handler.handler"),
-			Runtime:      aws.String("python3.6"),
-			Role:         aws.String("test"),
-			Publish:      aws.Bool(true),
+		input := newFunctionInput(functionName, "handler.handler", lambda.RuntimePython36)
+		input.Code = &lambda.FunctionCode{
+			ZipFile: zipContents.Bytes(),
 		}
-		_, err = services.LambdaClient().
-			CreateFunctionWithContext(ctx, input)
+		err = createFunction(ctx, client, input, opts)
 	}
 
 	return err
@@ -163,7 +162,7 @@ func AddTTL(
 	tableName string,
 	attrName string,
 ) (err error) {
-	fmt.Println("  - Adding TTL for", tableName, " table")
+	logInfo("Adding TTL to table", F("table", tableName), F("attribute", attrName))
 
 	ttlInput := &dynamodb.UpdateTimeToLiveInput{
 		TableName: aws.String(tableName),
@@ -183,7 +182,7 @@ func NewAPIGW(
 	ctx context.Context,
 	endpoint string,
 ) (err error) {
-	fmt.Println("  - Creating " + endpoint + " APIGW for testing")
+	logInfo("Creating APIGW for testing", F("endpoint", endpoint))
 
 	input := &apigateway.GetDomainNamesInput{}
 
@@ -197,7 +196,7 @@ func NewS3Bucket(
 	ctx context.Context,
 	bucketName string,
 ) (err error) {
-	fmt.Println("  - Creating " + bucketName + " S3 Bucket for testing")
+	logInfo("Creating S3 bucket for testing", F("bucket", bucketName))
 
 	input := &s3.CreateBucketInput{
 		Bucket: aws.String(bucketName),
@@ -208,7 +207,7 @@ func NewS3Bucket(
 }
 
 func NewS3BucketObject(ctx context.Context, bucketName string, key string, content []byte) error {
-	fmt.Println("  - Creating object for " + bucketName + " S3 Bucket for testing")
+	logInfo("Creating S3 bucket object for testing", F("bucket", bucketName), F("key", key))
 
 	testString := "Test s3 bucket object content"
 	reader := strings.NewReader(testString)
@@ -259,7 +258,7 @@ func NewSNSTopic(
 }
 
 func NewEC2Instance(ctx context.Context, endpoint string) error {
-	fmt.Println("  - Creating " + endpoint + " ec2 instance for testing")
+	logInfo("Creating ec2 instance for testing", F("endpoint", endpoint))
 	//
 	//res, err = services.EC2Client().RunInstancesWithContext(ctx, &ec2.RunInstancesInput{
 	//	AdditionalInfo:                    nil,
@@ -115,6 +115,34 @@ var _ = Describe("AWS Testing Services", func() {
 			Expect(err).Should(BeNil())
 			Expect(*output.Configuration.Runtime).Should(Equal("python3.6"))
 		})
+		It("should reject a lambda with an unsupported runtime", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "badRuntime", "return {}", WithLambdaRuntime("python9.9"))).ShouldNot(BeNil())
+		})
+		It("should create a lambda from a Go package", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewGoLambda(
+				testCtx,
+				"myGoFunction",
+				"./testdata/golambda",
+				WithLambdaRuntime(lambda.RuntimeProvidedAl2),
+				WithLambdaEnv(map[string]string{"GREETING": "hello"}),
+				WithLambdaMemory(256),
+				WithLambdaTimeout(10),
+			)).Should(BeNil())
+
+			output, err := services.LambdaClient().GetFunctionWithContext(
+				testCtx,
+				&lambda.GetFunctionInput{
+					FunctionName: aws.String("myGoFunction"),
+				},
+			)
+			Expect(err).Should(BeNil())
+			Expect(*output.Configuration.Runtime).Should(Equal(lambda.RuntimeProvidedAl2))
+			Expect(*output.Configuration.Environment.Variables["GREETING"]).Should(Equal("hello"))
+		})
 		It("should create a test S3 bucket", func() {
 			testCtx, td := NewTestDaemon()
 			defer td.Close()
@@ -0,0 +1,120 @@
+package lokalstack
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+// LambdaError is the payload returned by a function that failed
+type LambdaError struct {
+	ErrorMessage string        `json:"errorMessage"`
+	ErrorType    string        `json:"errorType"`
+	StackTrace   []interface{} `json:"stackTrace,omitempty"`
+}
+
+func (e *LambdaError) Error() string {
+	return e.ErrorType + ": " + e.ErrorMessage
+}
+
+// InvokeResult is the outcome of a synchronous lambda invocation
+type InvokeResult struct {
+	StatusCode      int64
+	Payload         []byte
+	FunctionError   string
+	Error           *LambdaError
+	LogTail         string
+	ExecutedVersion string
+	Duration        time.Duration
+}
+
+// Failed reports whether the function returned an error
+func (r *InvokeResult) Failed() bool {
+	return r.FunctionError != ""
+}
+
+// Decode unmarshals the JSON payload into v
+func (r *InvokeResult) Decode(v interface{}) error {
+	return json.Unmarshal(r.Payload, v)
+}
+
+// eventPayload marshals the event to JSON, []byte and json.RawMessage events are sent as is
+func eventPayload(event interface{}) ([]byte, error) {
+	switch e := event.(type) {
+	case []byte:
+		return e, nil
+	case json.RawMessage:
+		return e, nil
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// InvokeLambda invokes the function synchronously with the event, marshalled to
+// JSON, and returns its payload, error and the tail of its logs. Functions
+// returning an error don't cause an error, see InvokeResult.Failed.
+func InvokeLambda(ctx context.Context, functionName string, event interface{}) (res *InvokeResult, err error) {
+	var payload []byte
+	if payload, err = eventPayload(event); err != nil {
+		return nil, errors.Wrap(err, "marshalling lambda event")
+	}
+
+	start := time.Now()
+
+	var output *lambda.InvokeOutput
+	if output, err = services.LambdaClient().InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+		LogType:        aws.String(lambda.LogTypeTail),
+		Payload:        payload,
+	}); err == nil {
+		res = &InvokeResult{
+			StatusCode:      aws.Int64Value(output.StatusCode),
+			Payload:         output.Payload,
+			FunctionError:   aws.StringValue(output.FunctionError),
+			ExecutedVersion: aws.StringValue(output.ExecutedVersion),
+			Duration:        time.Since(start),
+		}
+
+		if output.LogResult != nil {
+			var logTail []byte
+			if logTail, err = base64.StdEncoding.DecodeString(*output.LogResult); err == nil {
+				res.LogTail = string(logTail)
+			}
+		}
+
+		if err == nil && res.Failed() {
+			res.Error = &LambdaError{}
+			if json.Unmarshal(res.Payload, res.Error) != nil {
+				res.Error.ErrorMessage = string(res.Payload)
+			}
+		}
+	}
+
+	return res, err
+}
+
+// InvokeLambdaAsync queues an asynchronous Event invocation of the function with the event
+func InvokeLambdaAsync(ctx context.Context, functionName string, event interface{}) (err error) {
+	var payload []byte
+	if payload, err = eventPayload(event); err != nil {
+		return errors.Wrap(err, "marshalling lambda event")
+	}
+
+	var output *lambda.InvokeOutput
+	if output, err = services.LambdaClient().InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	}); err == nil && aws.Int64Value(output.StatusCode) != 202 {
+		err = errors.Errorf("asynchronous invocation of %s returned status %d", functionName, aws.Int64Value(output.StatusCode))
+	}
+
+	return err
+}
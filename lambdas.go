@@ -0,0 +1,287 @@
+package lokalstack
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultLambdaRole is the execution role of functions deployed without WithLambdaRole
+	DefaultLambdaRole = "test"
+	// DefaultLambdaTimeout is the timeout in seconds of functions deployed without WithLambdaTimeout,
+	// longer than the AWS default to cover localstack cold starts
+	DefaultLambdaTimeout = 30
+	// DefaultLambdaMemory is the memory in MB of functions deployed without WithLambdaMemory
+	DefaultLambdaMemory = 128
+)
+
+// LambdaOption configures a lambda function before it is deployed. Settings not
+// covered by the With* options can be made with a custom option:
+//
+//	LambdaOption(func(input *lambda.CreateFunctionInput) {
+//		input.EphemeralStorage = &lambda.EphemeralStorage{Size: aws.Int64(1024)}
+//	})
+type LambdaOption func(*lambda.CreateFunctionInput)
+
+// newFunctionInput returns the input of a zip deployed function with the test defaults
+func newFunctionInput(functionName string, handler string, runtime string) *lambda.CreateFunctionInput {
+	return &lambda.CreateFunctionInput{
+		FunctionName: aws.String(functionName),
+		Handler:      aws.String(handler),
+		Runtime:      aws.String(runtime),
+		Role:         aws.String(DefaultLambdaRole),
+		Publish:      aws.Bool(true),
+		Timeout:      aws.Int64(DefaultLambdaTimeout),
+		MemorySize:   aws.Int64(DefaultLambdaMemory),
+	}
+}
+
+// validateFunctionInput rejects inputs localstack or AWS would refuse, before deploying them
+func validateFunctionInput(input *lambda.CreateFunctionInput) (err error) {
+	name := aws.StringValue(input.FunctionName)
+
+	if err = input.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid lambda function %s", name)
+	}
+
+	if aws.StringValue(input.PackageType) != lambda.PackageTypeImage {
+		if !contains(lambda.Runtime_Values(), aws.StringValue(input.Runtime)) {
+			return errors.Errorf("lambda function %s: unsupported runtime %q", name, aws.StringValue(input.Runtime))
+		}
+		if aws.StringValue(input.Handler) == "" {
+			return errors.Errorf("lambda function %s: handler is required", name)
+		}
+	}
+
+	if t := aws.Int64Value(input.Timeout); input.Timeout != nil && (t < 1 || t > 900) {
+		return errors.Errorf("lambda function %s: timeout %d outside 1-900 seconds", name, t)
+	}
+	if m := aws.Int64Value(input.MemorySize); input.MemorySize != nil && (m < 128 || m > 10240) {
+		return errors.Errorf("lambda function %s: memory %d outside 128-10240 MB", name, m)
+	}
+	if input.TracingConfig != nil && !contains(lambda.TracingMode_Values(), aws.StringValue(input.TracingConfig.Mode)) {
+		return errors.Errorf("lambda function %s: unsupported tracing mode %q", name, aws.StringValue(input.TracingConfig.Mode))
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// WithLambdaHandler sets the handler of the function, e.g. handler.handler
+func WithLambdaHandler(handler string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Handler = aws.String(handler)
+	}
+}
+
+// WithLambdaRuntime sets the runtime of the function
+func WithLambdaRuntime(runtime string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Runtime = aws.String(runtime)
+	}
+}
+
+// WithLambdaRole sets the execution role of the function
+func WithLambdaRole(role string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Role = aws.String(role)
+	}
+}
+
+// WithLambdaEnv adds environment variables to the function
+func WithLambdaEnv(vars map[string]string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		if input.Environment == nil {
+			input.Environment = &lambda.Environment{Variables: map[string]*string{}}
+		}
+		for k, v := range vars {
+			input.Environment.Variables[k] = aws.String(v)
+		}
+	}
+}
+
+// WithLambdaMemory sets the memory of the function in MB
+func WithLambdaMemory(mb int64) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.MemorySize = aws.Int64(mb)
+	}
+}
+
+// WithLambdaTimeout sets the timeout of the function in seconds
+func WithLambdaTimeout(seconds int64) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Timeout = aws.Int64(seconds)
+	}
+}
+
+// WithLambdaDescription sets the description of the function
+func WithLambdaDescription(description string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Description = aws.String(description)
+	}
+}
+
+// WithLambdaLayers adds layers, by version ARN, to the function
+func WithLambdaLayers(arns ...string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Layers = append(input.Layers, aws.StringSlice(arns)...)
+	}
+}
+
+// WithLambdaDeadLetterQueue sends failed asynchronous invocations to the SQS queue or SNS topic ARN
+func WithLambdaDeadLetterQueue(targetArn string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.DeadLetterConfig = &lambda.DeadLetterConfig{TargetArn: aws.String(targetArn)}
+	}
+}
+
+// WithLambdaTracing sets the X-Ray tracing mode of the function, Active or PassThrough
+func WithLambdaTracing(mode string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.TracingConfig = &lambda.TracingConfig{Mode: aws.String(mode)}
+	}
+}
+
+// WithLambdaPublish sets whether the first version of the function is published, which is the default
+func WithLambdaPublish(publish bool) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Publish = aws.Bool(publish)
+	}
+}
+
+// WithLambdaTags adds tags to the function
+func WithLambdaTags(tags map[string]string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		if input.Tags == nil {
+			input.Tags = map[string]*string{}
+		}
+		for k, v := range tags {
+			input.Tags[k] = aws.String(v)
+		}
+	}
+}
+
+// WithLambdaKMSKey sets the KMS key encrypting the environment variables of the function
+func WithLambdaKMSKey(keyArn string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.KMSKeyArn = aws.String(keyArn)
+	}
+}
+
+// WithLambdaVpc places the function in the given subnets and security groups
+func WithLambdaVpc(subnetIDs []string, securityGroupIDs []string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.VpcConfig = &lambda.VpcConfig{
+			SubnetIds:        aws.StringSlice(subnetIDs),
+			SecurityGroupIds: aws.StringSlice(securityGroupIDs),
+		}
+	}
+}
+
+// WithLambdaArchitectures sets the instruction set architectures of the function, e.g. x86_64
+func WithLambdaArchitectures(architectures ...string) LambdaOption {
+	return func(input *lambda.CreateFunctionInput) {
+		input.Architectures = aws.StringSlice(architectures)
+	}
+}
+
+// createFunction applies the options to the input and deploys the function
+func createFunction(
+	ctx context.Context,
+	client lambdaiface.LambdaAPI,
+	input *lambda.CreateFunctionInput,
+	opts []LambdaOption,
+) (err error) {
+	for _, opt := range opts {
+		opt(input)
+	}
+
+	if err = validateFunctionInput(input); err != nil {
+		return err
+	}
+
+	logInfo("Creating lambda function for testing",
+		F("function", aws.StringValue(input.FunctionName)), F("runtime", aws.StringValue(input.Runtime)))
+
+	_, err = client.CreateFunctionWithContext(ctx, input)
+
+	return err
+}
+
+// NewGoLambda cross-compiles the Go main package at pkg, as given to go build, for
+// linux/amd64 and deploys it to localstack. The binary is named bootstrap for the
+// provided.al2 runtime and handler for the default go1.x runtime.
+func NewGoLambda(
+	ctx context.Context,
+	functionName string,
+	pkg string,
+	opts ...LambdaOption,
+) (err error) {
+	input := newFunctionInput(functionName, "handler", lambda.RuntimeGo1X)
+	for _, opt := range opts {
+		opt(input)
+	}
+
+	binary := aws.StringValue(input.Handler)
+	if aws.StringValue(input.Runtime) == lambda.RuntimeProvidedAl2 {
+		binary = "bootstrap"
+	}
+
+	// Rejecting invalid settings before spending time on the build
+	input.Code = &lambda.FunctionCode{}
+	if err = validateFunctionInput(input); err == nil {
+		if input.Code.ZipFile, err = buildGoLambdaZip(ctx, pkg, binary); err == nil {
+			err = createFunction(ctx, services.LambdaClient(), input, nil)
+		}
+	}
+
+	return err
+}
+
+// buildGoLambdaZip builds the Go package for linux/amd64 and zips the executable under the given name
+func buildGoLambdaZip(ctx context.Context, pkg string, binary string) (res []byte, err error) {
+	var dir string
+	if dir, err = ioutil.TempDir("", "lokalstack-lambda"); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, binary)
+	args := []string{"build", "-trimpath", "-o", out}
+	if binary == "bootstrap" {
+		// The custom runtime talks to the Runtime API, the RPC server of go1.x is not needed
+		args = append(args, "-tags", "lambda.norpc")
+	}
+
+	cmd := exec.CommandContext(ctx, "go", append(args, pkg)...)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+
+	var output []byte
+	if output, err = cmd.CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "building %s: %s", pkg, output)
+	}
+
+	var contents []byte
+	if contents, err = ioutil.ReadFile(out); err == nil {
+		res, err = zipEntries([]zipEntry{{name: binary, mode: 0o755, contents: contents}})
+	}
+
+	return res, err
+}
@@ -0,0 +1,322 @@
+package lokalstack_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/kraneware/kws/services"
+	. "github.com/kraneware/lokalstack"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lambda Testing Services", func() {
+	Context("Packaging", func() {
+		It("should zip a directory deterministically", func() {
+			first, err := ZipDir("testdata/pylambda")
+			Expect(err).Should(BeNil())
+			second, err := ZipDir("testdata/pylambda")
+			Expect(err).Should(BeNil())
+			Expect(first).Should(Equal(second))
+		})
+		It("should deploy a lambda packaged from a directory", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			zipFile, err := ZipDir("testdata/pylambda")
+			Expect(err).Should(BeNil())
+			Expect(NewLambdaFromZip(testCtx, "myPackagedFunction", zipFile)).Should(BeNil())
+		})
+		It("should deploy a lambda from an uploaded S3 object", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			zipFile, err := ZipFiles(map[string][]byte{
+				"handler.py": []byte("def handler(event, context):\n    return event\n"),
+			})
+			Expect(err).Should(BeNil())
+			Expect(NewLambdaFromS3(testCtx, "myS3Function", "lambda-packages", "echo.zip", zipFile)).Should(BeNil())
+
+			output, err := services.LambdaClient().GetFunctionWithContext(
+				testCtx,
+				&lambda.GetFunctionInput{
+					FunctionName: aws.String("myS3Function"),
+				},
+			)
+			Expect(err).Should(BeNil())
+			Expect(*output.Configuration.Handler).Should(Equal("handler.handler"))
+		})
+	})
+	Context("Invocation", func() {
+		It("should invoke a lambda and match its payload", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "echoFunction", "return event")).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "echoFunction", map[string]string{"name": "test"})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(map[string]string{"name": "test"}))
+			Expect(result).Should(SucceedWithPayload(HaveKeyWithValue("name", "test")))
+		})
+		It("should invoke a failing lambda and match its error type", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "failingFunction", "raise ValueError('boom')")).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "failingFunction", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(FailWithErrorType("ValueError"))
+			Expect(result.Error.ErrorMessage).Should(Equal("boom"))
+		})
+		It("should queue an asynchronous invocation", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(InvokeLambdaAsync(testCtx, GenericEmptyLambda, map[string]string{})).Should(BeNil())
+		})
+	})
+	Context("Event sources", func() {
+		It("should trigger a lambda from a queue", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "queueConsumer", "return {}")).Should(BeNil())
+
+			queue, err := NewSQS(testCtx, "triggerQueue", nil)
+			Expect(err).Should(BeNil())
+
+			mappingID, err := NewSQSEventSource(testCtx, "queueConsumer", *queue.QueueUrl, 10)
+			Expect(err).Should(BeNil())
+
+			mapping, err := services.LambdaClient().GetEventSourceMappingWithContext(testCtx, &lambda.GetEventSourceMappingInput{
+				UUID: aws.String(mappingID),
+			})
+			Expect(err).Should(BeNil())
+			Expect(mapping.State).Should(Equal(aws.String("Enabled")))
+			Expect(mapping.BatchSize).Should(Equal(aws.Int64(10)))
+		})
+		It("should trigger a lambda from a table stream", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "streamConsumer", "return {}")).Should(BeNil())
+			Expect(NewTableSpec("streamedTable").
+				HashKey("id", dynamodb.ScalarAttributeTypeS).
+				Stream(dynamodb.StreamViewTypeNewAndOldImages).
+				Create(testCtx)).Should(BeNil())
+
+			// A batch size of 0 keeps the default of the stream
+			mappingID, err := NewDynamoDBStreamEventSource(
+				testCtx, "streamConsumer", "streamedTable", 0, lambda.EventSourcePositionTrimHorizon,
+			)
+			Expect(err).Should(BeNil())
+
+			table, err := services.DynamoDbClient().DescribeTableWithContext(testCtx, &dynamodb.DescribeTableInput{
+				TableName: aws.String("streamedTable"),
+			})
+			Expect(err).Should(BeNil())
+
+			mapping, err := services.LambdaClient().GetEventSourceMappingWithContext(testCtx, &lambda.GetEventSourceMappingInput{
+				UUID: aws.String(mappingID),
+			})
+			Expect(err).Should(BeNil())
+			Expect(mapping.State).Should(Equal(aws.String("Enabled")))
+			Expect(mapping.EventSourceArn).Should(Equal(table.Table.LatestStreamArn))
+		})
+		It("should reject a table without a stream", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewTableSpec("unstreamedTable").
+				HashKey("id", dynamodb.ScalarAttributeTypeS).
+				Create(testCtx)).Should(BeNil())
+
+			_, err := NewDynamoDBStreamEventSource(testCtx, GenericEmptyLambda, "unstreamedTable", 10, "")
+			Expect(err).Should(MatchError("table unstreamedTable has no stream"))
+		})
+		It("should subscribe a lambda to a topic", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "topicConsumer", "return {}")).Should(BeNil())
+
+			topic, err := NewSNSTopic(testCtx, "triggerTopic", nil)
+			Expect(err).Should(BeNil())
+
+			subscriptionArn, err := SubscribeLambdaToSNS(testCtx, *topic.TopicArn, "topicConsumer")
+			Expect(err).Should(BeNil())
+			Expect(subscriptionArn).ShouldNot(BeEmpty())
+		})
+	})
+	Context("Versions and aliases", func() {
+		It("should update, publish and alias a lambda", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "versionedFunction", "return 1")).Should(BeNil())
+
+			zipFile, err := ZipFiles(map[string][]byte{
+				"handler.py": []byte("def handler(event, context):\n    return 2\n"),
+			})
+			Expect(err).Should(BeNil())
+			Expect(UpdateLambdaCode(testCtx, "versionedFunction", zipFile)).Should(BeNil())
+			Expect(UpdateLambdaConfig(testCtx, "versionedFunction", WithLambdaTimeout(20))).Should(BeNil())
+
+			version, err := PublishVersion(testCtx, "versionedFunction", "second")
+			Expect(err).Should(BeNil())
+			Expect(CreateAlias(testCtx, "versionedFunction", "live", version, nil)).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "versionedFunction:live", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(2))
+		})
+	})
+	Context("Python handlers", func() {
+		It("should indent a multi-line handler body", func() {
+			source := NewPythonModule().
+				Import("json").
+				Body(`
+					body = json.dumps(event)
+					if body:
+					    return body
+					return None
+				`).
+				Source()
+			Expect(source).Should(Equal("import json\n\n\ndef handler(event, context):\n" +
+				"    body = json.dumps(event)\n" +
+				"    if body:\n" +
+				"        return body\n" +
+				"    return None\n"))
+		})
+		It("should indent multi-line code opening a block", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLambda(testCtx, "blockBody", "if event:\n  return event\nreturn 0")).Should(BeNil())
+			Expect(NewLambda(testCtx, "multiLine", "x = 2\nreturn x")).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "blockBody", map[string]int{"n": 1})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(map[string]int{"n": 1}))
+
+			result, err = InvokeLambda(testCtx, "multiLine", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(2))
+		})
+		It("should keep the two space indent of legacy lambda code", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewLegacyLambda(testCtx, "legacyIndent", "x = 1\n  return x")).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "legacyIndent", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(1))
+		})
+		It("should deploy canned downstream stubs", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+			Expect(NewPythonLambda(testCtx, "echoStub", PythonEcho())).Should(BeNil())
+			Expect(NewPythonLambda(testCtx, "raiseStub", PythonRaise("DownstreamError", "unavailable"))).Should(BeNil())
+
+			result, err := InvokeLambda(testCtx, "echoStub", map[string]string{"id": "1"})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(map[string]string{"id": "1"}))
+
+			result, err = InvokeLambda(testCtx, "raiseStub", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(FailWithErrorType("DownstreamError"))
+		})
+	})
+	Context("In-process emulator", func() {
+		It("should invoke Go handlers without a container", func() {
+			emulator, err := NewLambdaEmulator()
+			Expect(err).Should(BeNil())
+			emulator.Use()
+			defer func() {
+				Expect(emulator.Close()).Should(BeNil())
+			}()
+
+			emulator.Register("goEcho", func(ctx context.Context, event map[string]string) (map[string]string, error) {
+				return event, nil
+			})
+			emulator.Register("goFailure", func() error {
+				return &emulatedError{}
+			})
+
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			result, err := InvokeLambda(testCtx, "goEcho", map[string]string{"id": "1"})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(map[string]string{"id": "1"}))
+
+			result, err = InvokeLambda(testCtx, "goFailure", map[string]string{})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(FailWithErrorType("emulatedError"))
+		})
+	})
+	Context("Runtime API", func() {
+		It("should run the bootstrap loop of a Go binary", func() {
+			dir, err := ioutil.TempDir("", "runtime")
+			Expect(err).Should(BeNil())
+			defer os.RemoveAll(dir)
+
+			build := exec.Command("go", "build", "-o", filepath.Join(dir, "bootstrap"), "./testdata/golambda")
+			Expect(build.Run()).Should(BeNil())
+
+			runtime, err := NewRuntimeAPI("greeter")
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(runtime.Close()).Should(BeNil())
+			}()
+
+			bootstrap := exec.Command(filepath.Join(dir, "bootstrap"))
+			bootstrap.Env = append(os.Environ(), append(runtime.Env(), "GREETING=Hello")...)
+			Expect(bootstrap.Start()).Should(BeNil())
+			defer func() {
+				_ = bootstrap.Process.Kill()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			result, err := runtime.Invoke(ctx, map[string]string{"name": "lambda"})
+			Expect(err).Should(BeNil())
+			Expect(result).Should(SucceedWithPayload(map[string]string{"greeting": "Hello lambda"}))
+
+			result, err = runtime.Invoke(ctx, "not an event")
+			Expect(err).Should(BeNil())
+			Expect(result).Should(FailWithErrorType("UnmarshalTypeError"))
+
+			Expect(runtime.Invocations()).Should(HaveLen(2))
+			Expect(runtime.InitError()).Should(BeNil())
+		})
+		It("should give up queueing events once the context is done", func() {
+			runtime, err := NewRuntimeAPI("idle")
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(runtime.Close()).Should(BeNil())
+			}()
+
+			for i := 0; i < 100; i++ {
+				_, err = runtime.Push(context.Background(), map[string]int{"n": i})
+				Expect(err).Should(BeNil())
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			_, err = runtime.Invoke(ctx, map[string]int{"n": 100})
+			Expect(errors.Cause(err)).Should(Equal(context.DeadlineExceeded))
+			Expect(runtime.Invocations()).Should(HaveLen(100))
+		})
+	})
+})
+
+type emulatedError struct{}
+
+func (*emulatedError) Error() string {
+	return "emulated failure"
+}
@@ -0,0 +1,142 @@
+package lokalstack
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Field is a key value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a log entry field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger receives the log entries of the package and its stacks
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+var (
+	// logger receives the log entries of the package level helpers
+	logger   Logger       = NewWriterLogger(os.Stdout, InfoLevel) // nolint:gochecknoglobals
+	loggerMu sync.RWMutex                                         // nolint:gochecknoglobals
+)
+
+// SetLogger sets the logger of the package level helpers and of the stacks started
+// without WithLogger. Use NopLogger to silence them.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func currentLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+func logInfo(msg string, fields ...Field) {
+	currentLogger().Log(InfoLevel, msg, fields...)
+}
+
+func logWarn(msg string, fields ...Field) {
+	currentLogger().Log(WarnLevel, msg, fields...)
+}
+
+// formatEntry renders a log entry as a single line of text
+func formatEntry(level Level, msg string, fields []Field) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-5s %s", level, msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	return b.String()
+}
+
+// writerLogger writes log entries at or above a level to a writer
+type writerLogger struct {
+	w   io.Writer
+	min Level
+	mu  sync.Mutex
+}
+
+// NewWriterLogger returns a logger writing entries at or above min to w, one line
+// per entry. With Ginkgo, NewWriterLogger(GinkgoWriter, DebugLevel) only shows
+// the entries of failed specs.
+func NewWriterLogger(w io.Writer, min Level) Logger {
+	return &writerLogger{w: w, min: min}
+}
+
+func (l *writerLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.min {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, formatEntry(level, msg, fields))
+}
+
+// TestingT is the part of testing.TB used by the test logger
+type TestingT interface {
+	Helper()
+	Logf(format string, args ...interface{})
+}
+
+type testLogger struct {
+	t TestingT
+}
+
+// NewTestLogger returns a logger writing every entry to the test log, shown
+// for failed tests or with go test -v
+func NewTestLogger(t TestingT) Logger {
+	return &testLogger{t: t}
+}
+
+func (l *testLogger) Log(level Level, msg string, fields ...Field) {
+	l.t.Helper()
+	l.t.Logf("%s", formatEntry(level, msg, fields))
+}
+
+type nopLogger struct{}
+
+// NopLogger returns a logger discarding every entry
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Log(Level, string, ...Field) {}
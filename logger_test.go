@@ -0,0 +1,54 @@
+package lokalstack_test
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/kraneware/lokalstack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	Context("Writer logger", func() {
+		It("should write entries at or above its level with their fields", func() {
+			var buf bytes.Buffer
+			logger := NewWriterLogger(&buf, InfoLevel)
+
+			logger.Log(DebugLevel, "hidden")
+			logger.Log(WarnLevel, "Creating table for testing", F("table", "testTable"))
+
+			Expect(buf.String()).Should(Equal("WARN  Creating table for testing table=testTable\n"))
+		})
+	})
+	Context("Test logger", func() {
+		It("should pass every entry with its fields to the test log", func() {
+			t := &fakeT{}
+			logger := NewTestLogger(t)
+
+			logger.Log(DebugLevel, "Starting localstack container", F("image", "localstack/localstack:0.11.3"))
+			logger.Log(ErrorLevel, "Could not stop localstack container")
+
+			Expect(t.helpers).Should(Equal(2))
+			Expect(t.lines).Should(Equal([]string{
+				"DEBUG Starting localstack container image=localstack/localstack:0.11.3",
+				"ERROR Could not stop localstack container",
+			}))
+		})
+	})
+})
+
+// fakeT records the calls of a test logger
+type fakeT struct {
+	helpers int
+	lines   []string
+}
+
+func (t *fakeT) Helper() {
+	t.helpers++
+}
+
+func (t *fakeT) Logf(format string, args ...interface{}) {
+	t.lines = append(t.lines, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,199 @@
+package lokalstack
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	dc "github.com/ory/dockertest/docker"
+	"github.com/pkg/errors"
+)
+
+// unsafeFileChars matches characters replaced when a spec name becomes a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`) // nolint:gochecknoglobals
+
+// logs copies the container logs to w, following them while follow is set
+func (s *Stack) logs(ctx context.Context, w io.Writer, follow bool) error {
+	if s.resource == nil || s.pool == nil {
+		return errors.New("stack has no container to read logs from")
+	}
+
+	return s.pool.Client.Logs(dc.LogsOptions{
+		Context:      ctx,
+		Container:    s.resource.Container.ID,
+		OutputStream: w,
+		ErrorStream:  w,
+		Stdout:       true,
+		Stderr:       true,
+		Follow:       follow,
+	})
+}
+
+// StreamLogs copies the container stdout and stderr to w as they are written,
+// until the context is done or the container stops
+func (s *Stack) StreamLogs(ctx context.Context, w io.Writer) (err error) {
+	if err = s.logs(ctx, w, true); ctx.Err() != nil {
+		err = nil
+	}
+	return err
+}
+
+// flusher is a log writer holding back output until it is flushed, e.g. the
+// incomplete last line kept by LogfWriter
+type flusher interface {
+	Flush() error
+}
+
+// streamLogsInBackground streams the container logs to w until the stack is closed,
+// flushing w once the stream ended
+func (s *Stack) streamLogsInBackground(w io.Writer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_ = s.StreamLogs(ctx, w)
+	}()
+
+	// Waiting for the stream to end guarantees w is not written to once the stack
+	// is closed, testing.T.Logf panics when called after the test completed
+	s.stopLogs = func() {
+		cancel()
+		<-done
+
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				s.log(WarnLevel, "Could not flush the container logs", F("error", err))
+			}
+		}
+	}
+}
+
+// stopStreamingLogs stops the background log stream, if any, and waits for its last write
+func (s *Stack) stopStreamingLogs() {
+	if s.stopLogs != nil {
+		s.stopLogs()
+		s.stopLogs = nil
+	}
+}
+
+// Logs returns everything the container wrote to stdout and stderr so far
+func (s *Stack) Logs(ctx context.Context) (string, error) {
+	var buf bytes.Buffer
+	err := s.logs(ctx, &buf, false)
+	return buf.String(), err
+}
+
+// DumpLogs writes the container logs to the given file, creating its directory
+func (s *Stack) DumpLogs(path string) (err error) {
+	var logs string
+	if logs, err = s.Logs(context.Background()); err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = ioutil.WriteFile(path, []byte(logs), 0o644)
+		}
+	}
+
+	return err
+}
+
+// DumpLogsOnFailure writes the container logs to target/testing/<package>/<name>.log
+// when failed is set, and returns the path written. With Ginkgo:
+//
+//	AfterEach(func() {
+//		desc := CurrentGinkgoTestDescription()
+//		_, _ = stack.DumpLogsOnFailure(desc.Failed, desc.FullTestText)
+//	})
+func (s *Stack) DumpLogsOnFailure(failed bool, name string) (path string, err error) {
+	if failed {
+		if path, err = testingDir(); err == nil {
+			path = filepath.Join(path, unsafeFileChars.ReplaceAllString(name, "_")+".log")
+			err = s.DumpLogs(path)
+		}
+	}
+
+	return path, err
+}
+
+// DumpLogsOnFailure writes the logs of the container started by StartContainer
+// to target/testing/<package>/<name>.log when failed is set
+func DumpLogsOnFailure(failed bool, name string) (string, error) {
+	if defaultStack == nil {
+		return "", errors.New("Container not started")
+	}
+	return defaultStack.DumpLogsOnFailure(failed, name)
+}
+
+// testingDir returns target/testing/<package> under the module root, the
+// directory the Makefile writes the test results of the current package to
+func testingDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	root := dir
+	for {
+		if _, err = os.Stat(filepath.Join(root, "go.mod")); err == nil {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", errors.New("no go.mod found above " + dir)
+		}
+		root = parent
+	}
+
+	pkg, err := filepath.Rel(root, dir)
+	return filepath.Join(root, "target", "testing", pkg), err
+}
+
+// logfWriter writes complete lines to a Logf function
+type logfWriter struct {
+	logf func(format string, args ...interface{})
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+// LogfWriter returns a writer passing each complete line to logf, e.g. testing.T.Logf,
+// so the container logs can be streamed to the test logger. Flush passes the
+// incomplete last line, a stack flushes it once its log stream ends.
+func LogfWriter(logf func(format string, args ...interface{})) io.Writer {
+	return &logfWriter{logf: logf}
+}
+
+func (w *logfWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			w.buf.WriteString(line)
+			break
+		}
+		w.logf("%s", strings.TrimRight(line, "\r\n"))
+	}
+
+	return len(p), nil
+}
+
+// Flush passes the incomplete last line, if any, to logf
+func (w *logfWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.logf("%s", strings.TrimRight(w.buf.String(), "\r"))
+		w.buf.Reset()
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package lokalstack
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/types"
+	"github.com/pkg/errors"
+)
+
+// invokeResult returns the actual value of a lambda matcher as an InvokeResult
+func invokeResult(actual interface{}) (*InvokeResult, error) {
+	res, ok := actual.(*InvokeResult)
+	if !ok || res == nil {
+		return nil, errors.Errorf("expected a non nil *InvokeResult, got\n%s", format.Object(actual, 1))
+	}
+	return res, nil
+}
+
+type succeedWithPayloadMatcher struct {
+	expected interface{}
+	failure  string
+}
+
+// SucceedWithPayload succeeds when the InvokeResult has no function error and its
+// payload matches expected. A matcher is applied to the decoded payload, []byte
+// and strings are compared as JSON, other values are marshalled to JSON first.
+func SucceedWithPayload(expected interface{}) types.GomegaMatcher {
+	return &succeedWithPayloadMatcher{expected: expected}
+}
+
+func (m *succeedWithPayloadMatcher) Match(actual interface{}) (success bool, err error) {
+	var res *InvokeResult
+	if res, err = invokeResult(actual); err != nil {
+		return false, err
+	}
+
+	if res.Failed() {
+		m.failure = fmt.Sprintf("Expected lambda to succeed, it failed with %s error\n%s", res.FunctionError, res.Payload)
+		return false, nil
+	}
+
+	var inner types.GomegaMatcher
+	var value interface{}
+
+	switch e := m.expected.(type) {
+	case types.GomegaMatcher:
+		inner = e
+		err = res.Decode(&value)
+	case []byte:
+		inner, value = gomega.MatchJSON(e), res.Payload
+	case string:
+		inner, value = gomega.MatchJSON(e), res.Payload
+	default:
+		var expected []byte
+		if expected, err = json.Marshal(e); err == nil {
+			inner, value = gomega.MatchJSON(expected), res.Payload
+		}
+	}
+
+	if err == nil {
+		if success, err = inner.Match(value); err == nil && !success {
+			m.failure = inner.FailureMessage(value)
+		}
+	}
+
+	return success, err
+}
+
+func (m *succeedWithPayloadMatcher) FailureMessage(actual interface{}) string {
+	return m.failure
+}
+
+func (m *succeedWithPayloadMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected lambda not to succeed with payload\n%s", format.Object(m.expected, 1))
+}
+
+type failWithErrorTypeMatcher struct {
+	errorType string
+}
+
+// FailWithErrorType succeeds when the InvokeResult has a function error of the given type
+func FailWithErrorType(errorType string) types.GomegaMatcher {
+	return &failWithErrorTypeMatcher{errorType: errorType}
+}
+
+func (m *failWithErrorTypeMatcher) Match(actual interface{}) (bool, error) {
+	res, err := invokeResult(actual)
+	if err != nil {
+		return false, err
+	}
+	return res.Failed() && res.Error != nil && res.Error.ErrorType == m.errorType, nil
+}
+
+func (m *failWithErrorTypeMatcher) FailureMessage(actual interface{}) string {
+	res, _ := invokeResult(actual)
+	if !res.Failed() {
+		return fmt.Sprintf("Expected lambda to fail with %s, it succeeded with payload\n%s", m.errorType, res.Payload)
+	}
+	return fmt.Sprintf("Expected lambda to fail with %s, it failed with\n%s", m.errorType, res.Payload)
+}
+
+func (m *failWithErrorTypeMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected lambda not to fail with %s", m.errorType)
+}
+
+type matchSnapshotMatcher struct {
+	expected *TableSnapshot
+	golden   string
+	failure  string
+}
+
+// MatchSnapshot succeeds when the *TableSnapshot holds the same items as expected,
+// the failure message lists the items added, removed and changed
+func MatchSnapshot(expected *TableSnapshot) types.GomegaMatcher {
+	return &matchSnapshotMatcher{expected: expected}
+}
+
+// MatchGoldenSnapshot succeeds when the *TableSnapshot holds the same items as the
+// golden file, see TableSnapshot.CompareGolden
+func MatchGoldenSnapshot(path string) types.GomegaMatcher {
+	return &matchSnapshotMatcher{golden: path}
+}
+
+func (m *matchSnapshotMatcher) Match(actual interface{}) (bool, error) {
+	m.failure = ""
+
+	s, ok := actual.(*TableSnapshot)
+	if !ok || s == nil {
+		return false, errors.Errorf("expected a non nil *TableSnapshot, got\n%s", format.Object(actual, 1))
+	}
+
+	if m.golden != "" {
+		if err := s.CompareGolden(m.golden); err != nil {
+			m.failure = err.Error()
+		}
+	} else if diff := s.Diff(m.expected); diff != "" {
+		m.failure = "Expected table snapshots to match\n" + diff
+	}
+
+	return m.failure == "", nil
+}
+
+func (m *matchSnapshotMatcher) FailureMessage(actual interface{}) string {
+	return m.failure
+}
+
+func (m *matchSnapshotMatcher) NegatedFailureMessage(actual interface{}) string {
+	return "Expected table snapshots to differ"
+}
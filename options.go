@@ -0,0 +1,341 @@
+package lokalstack
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/kraneware/kws/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRepository is the localstack image started when no image is configured
+	DefaultRepository = "localstack/localstack"
+	// DefaultTag is the localstack image tag started when no tag is configured
+	DefaultTag = "0.11.3"
+
+	// EnvEndpoint attaches to an already running localstack, e.g. http://localhost:4566
+	EnvEndpoint = "LOKALSTACK_ENDPOINT"
+	// EnvReuse enables reusing a labelled container across test binaries
+	EnvReuse = "LOKALSTACK_REUSE"
+	// EnvReadyTimeout overrides how long startup waits for the services, e.g. 3m
+	EnvReadyTimeout = "LOKALSTACK_READY_TIMEOUT"
+	// EnvImage overrides the localstack image, optionally including the tag and digest (repository[:tag][@digest])
+	EnvImage = "LOKALSTACK_IMAGE"
+	// EnvTag overrides the localstack image tag
+	EnvTag = "LOKALSTACK_TAG"
+	// EnvPrivileged overrides whether the container runs privileged
+	EnvPrivileged = "LOKALSTACK_PRIVILEGED"
+	// EnvServices overrides the comma separated list of localstack services to start
+	EnvServices = "LOKALSTACK_SERVICES"
+	// EnvContainerPrefix prefixes variables passed to the container, LOKALSTACK_CONTAINER_DEBUG=0 sets DEBUG=0
+	EnvContainerPrefix = "LOKALSTACK_CONTAINER_"
+)
+
+// PortMode selects how the localstack container ports are published on the host
+type PortMode int
+
+const (
+	// FixedPorts binds the container ports to the same port numbers on localhost
+	FixedPorts PortMode = iota
+	// DynamicPorts lets Docker choose free host ports, which are read back once the container runs
+	DynamicPorts
+)
+
+// Options holds the settings used to start a Stack
+type Options struct {
+	Region        string
+	Credentials   *credentials.Credentials
+	PortMode      PortMode
+	EndpointMode  EndpointMode
+	Repository    string
+	Tag           string
+	Digest        string
+	Env           []string
+	Privileged    bool
+	Services      []string
+	ReadyServices []string
+	ReadyTimeout  time.Duration
+	ProbeTimeout  time.Duration
+	Backoff       Backoff
+	LogWriter     io.Writer
+	Logger        Logger
+	Reuse         bool
+	HandleSignals bool
+
+	err error
+}
+
+// Option configures a Stack before it is started
+type Option func(*Options)
+
+func defaultOptions() *Options {
+	return &Options{
+		Region:       TestRegion,
+		Credentials:  GetDefaultLocalstackCredentials(),
+		PortMode:     FixedPorts,
+		EndpointMode: AutoEndpoints,
+		Repository:   DefaultRepository,
+		Tag:          DefaultTag,
+		// Env should be []string{} for python lambdas
+		// should be []string{"LAMBDA_EXECUTOR=docker"}, for non-python lambdas
+		// Using python for test downstream lambdas
+		Env: []string{
+			"DEBUG=1",
+			"LOCALSTACK_API_KEY=" + os.Getenv("LOCALSTACK_API_KEY"), // Will fail if Pro is not activated. Lambdas only available in Pro
+			"EXTERNAL_SERVICE_PORTS_START=4510",
+			"EXTERNAL_SERVICE_PORTS_END=4597",
+		},
+		// Privileged access is required to start docker inside the container
+		Privileged:    true,
+		HandleSignals: true,
+		ReadyTimeout:  time.Minute,
+		ProbeTimeout:  10 * time.Second,
+		Backoff: Backoff{
+			Initial:    500 * time.Millisecond,
+			Max:        5 * time.Second,
+			Multiplier: 1.5,
+		},
+	}
+}
+
+func newOptions(opts ...Option) *Options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// setEnv sets a KEY=VALUE variable, replacing any previous value of the key
+func setEnv(env []string, key string, value string) []string {
+	res := make([]string, 0, len(env)+1)
+	for _, v := range env {
+		if !strings.HasPrefix(v, key+"=") {
+			res = append(res, v)
+		}
+	}
+	return append(res, key+"="+value)
+}
+
+// splitList splits a comma separated list, dropping blank entries
+func splitList(list string) (res []string) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+// currentRegion returns the region of the kws configuration, TestRegion when unset
+func currentRegion() string {
+	if config.Region != "" {
+		return config.Region
+	}
+	return TestRegion
+}
+
+// WithRegion sets the AWS region the stack is configured for
+func WithRegion(region string) Option {
+	return func(o *Options) {
+		o.Region = region
+	}
+}
+
+// WithCredentials sets the credentials used to talk to the stack
+func WithCredentials(creds *credentials.Credentials) Option {
+	return func(o *Options) {
+		o.Credentials = creds
+	}
+}
+
+// WithPortMode sets how the container ports are published on the host
+func WithPortMode(mode PortMode) Option {
+	return func(o *Options) {
+		o.PortMode = mode
+	}
+}
+
+// WithDynamicPorts lets Docker choose free host ports for the container
+func WithDynamicPorts() Option {
+	return WithPortMode(DynamicPorts)
+}
+
+// WithEndpointMode sets whether services are reached on their legacy ports or the edge port
+func WithEndpointMode(mode EndpointMode) Option {
+	return func(o *Options) {
+		o.EndpointMode = mode
+	}
+}
+
+// image returns the reference of the localstack image, repository:tag[@digest]
+func (o *Options) image() string {
+	return o.Repository + ":" + o.imageTag()
+}
+
+// imageTag returns the tag of the localstack image followed by its digest, if any.
+// Docker resolves a reference carrying both a tag and a digest by the digest.
+func (o *Options) imageTag() string {
+	tag := o.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	if o.Digest != "" {
+		tag += "@" + o.Digest
+	}
+	return tag
+}
+
+// WithImage sets the localstack image repository, e.g. a private registry mirror.
+// A tag and a digest may be given as repository[:tag][@digest], the port of a
+// registry such as host:5000/localstack/localstack is not taken for a tag.
+// A digest pins the image, the tag then only selects the endpoint mode.
+func WithImage(image string) Option {
+	return func(o *Options) {
+		o.Repository, o.Digest = image, ""
+		if i := strings.Index(image, "@"); i >= 0 {
+			o.Repository, o.Digest = image[:i], image[i+1:]
+		}
+		if i := strings.LastIndex(o.Repository, ":"); i > 0 && !strings.Contains(o.Repository[i:], "/") {
+			o.Repository, o.Tag = o.Repository[:i], o.Repository[i+1:]
+		}
+	}
+}
+
+// WithTag sets the localstack image tag
+func WithTag(tag string) Option {
+	return func(o *Options) {
+		o.Tag = tag
+	}
+}
+
+// WithEnv sets an environment variable of the localstack container
+func WithEnv(key string, value string) Option {
+	return func(o *Options) {
+		o.Env = setEnv(o.Env, key, value)
+	}
+}
+
+// WithPrivileged sets whether the container runs privileged
+func WithPrivileged(privileged bool) Option {
+	return func(o *Options) {
+		o.Privileged = privileged
+	}
+}
+
+// WithServices limits localstack to the given services, e.g. "dynamodb", "sqs"
+func WithServices(services ...string) Option {
+	return func(o *Options) {
+		o.Services = services
+		o.Env = setEnv(o.Env, "SERVICES", strings.Join(services, ","))
+	}
+}
+
+// WithReuse reuses a healthy container started with the same settings by another
+// test binary, or starts one that later test binaries can reuse. Closing the stack
+// leaves the container running until ReapIdle removes it.
+func WithReuse() Option {
+	return func(o *Options) {
+		o.Reuse = true
+	}
+}
+
+// WithSignalHandling sets whether the container is removed when the test binary
+// receives SIGINT or SIGTERM, which is the default
+func WithSignalHandling(handle bool) Option {
+	return func(o *Options) {
+		o.HandleSignals = handle
+	}
+}
+
+// WithReadyServices sets the services startup waits on, by default the services
+// passed to WithServices or DefaultReadyServices
+func WithReadyServices(services ...string) Option {
+	return func(o *Options) {
+		o.ReadyServices = services
+	}
+}
+
+// WithReadyTimeout sets how long startup waits for the services to accept requests
+func WithReadyTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ReadyTimeout = timeout
+	}
+}
+
+// WithProbeTimeout sets how long a single readiness probe may take
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ProbeTimeout = timeout
+	}
+}
+
+// WithBackoff sets the delay policy between the attempts of a readiness probe
+func WithBackoff(backoff Backoff) Option {
+	return func(o *Options) {
+		o.Backoff = backoff
+	}
+}
+
+// WithLogWriter streams the container stdout and stderr to w while the stack runs,
+// see LogfWriter to stream them to the test logger
+func WithLogWriter(w io.Writer) Option {
+	return func(o *Options) {
+		o.LogWriter = w
+	}
+}
+
+// WithLogger sets the logger of the stack, by default the logger passed to SetLogger
+func WithLogger(l Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+// FromEnvironment applies the LOKALSTACK_* environment variables on top of the
+// options given before it
+func FromEnvironment() Option {
+	return func(o *Options) {
+		if image := os.Getenv(EnvImage); image != "" {
+			WithImage(image)(o)
+		}
+		if tag := os.Getenv(EnvTag); tag != "" {
+			WithTag(tag)(o)
+		}
+		if privileged := os.Getenv(EnvPrivileged); privileged != "" {
+			if v, err := strconv.ParseBool(privileged); err == nil {
+				WithPrivileged(v)(o)
+			} else {
+				o.err = errors.Wrapf(err, "invalid %s", EnvPrivileged)
+			}
+		}
+		if reuse := os.Getenv(EnvReuse); reuse != "" {
+			if v, err := strconv.ParseBool(reuse); err == nil {
+				o.Reuse = v
+			} else {
+				o.err = errors.Wrapf(err, "invalid %s", EnvReuse)
+			}
+		}
+		if timeout := os.Getenv(EnvReadyTimeout); timeout != "" {
+			if v, err := time.ParseDuration(timeout); err == nil {
+				WithReadyTimeout(v)(o)
+			} else {
+				o.err = errors.Wrapf(err, "invalid %s", EnvReadyTimeout)
+			}
+		}
+		if services := os.Getenv(EnvServices); services != "" {
+			WithServices(splitList(services)...)(o)
+		}
+		for _, v := range os.Environ() {
+			if strings.HasPrefix(v, EnvContainerPrefix) {
+				kv := strings.SplitN(strings.TrimPrefix(v, EnvContainerPrefix), "=", 2)
+				WithEnv(kv[0], kv[1])(o)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package lokalstack
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kraneware/kws/services"
+)
+
+// zipEpoch is the modification time of every zipped file, so that the same
+// contents always produce the same archive
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC) // nolint:gochecknoglobals
+
+type zipEntry struct {
+	name     string
+	mode     fs.FileMode
+	contents []byte
+}
+
+// zipEntries writes the entries sorted by name into a zip archive
+func zipEntries(entries []zipEntry) (res []byte, err error) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+
+	for i := 0; err == nil && i < len(entries); i++ {
+		header := &zip.FileHeader{
+			Name:     entries[i].name,
+			Method:   zip.Deflate,
+			Modified: zipEpoch,
+		}
+		header.SetMode(entries[i].mode)
+
+		var f io.Writer
+		if f, err = writer.CreateHeader(header); err == nil {
+			_, err = f.Write(entries[i].contents)
+		}
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return buf.Bytes(), err
+}
+
+// ZipFiles packages the given file contents by name, e.g. "handler.py" or
+// "lib/helpers.py", into a deterministic zip archive with 0644 file modes
+func ZipFiles(files map[string][]byte) ([]byte, error) {
+	entries := make([]zipEntry, 0, len(files))
+	for name, contents := range files {
+		entries = append(entries, zipEntry{name: name, mode: 0o644, contents: contents})
+	}
+
+	return zipEntries(entries)
+}
+
+// ZipFS packages every regular file of fsys into a deterministic zip archive,
+// preserving the file modes
+func ZipFS(fsys fs.FS) (res []byte, err error) {
+	var entries []zipEntry
+
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err == nil {
+			var contents []byte
+			if contents, err = fs.ReadFile(fsys, path); err == nil {
+				entries = append(entries, zipEntry{name: path, mode: info.Mode().Perm(), contents: contents})
+			}
+		}
+
+		return err
+	})
+
+	if err == nil {
+		res, err = zipEntries(entries)
+	}
+
+	return res, err
+}
+
+// ZipDir packages every regular file under dir into a deterministic zip archive,
+// preserving the file modes
+func ZipDir(dir string) ([]byte, error) {
+	return ZipFS(os.DirFS(dir))
+}
+
+// NewLambdaFromZip deploys a function packaged with ZipFiles, ZipFS or ZipDir to
+// localstack. The options may change any setting, the runtime defaults to python3.6
+// with the handler.handler handler.
+func NewLambdaFromZip(
+	ctx context.Context,
+	functionName string,
+	zipFile []byte,
+	opts ...LambdaOption,
+) error {
+	input := newFunctionInput(functionName, "handler.handler", lambda.RuntimePython36)
+	input.Code = &lambda.FunctionCode{ZipFile: zipFile}
+
+	return createFunction(ctx, services.LambdaClient(), input, opts)
+}
+
+// NewLambdaFromS3 uploads the zip archive to the given local bucket, creating the
+// bucket if needed, and deploys the function from that S3 object
+func NewLambdaFromS3(
+	ctx context.Context,
+	functionName string,
+	bucketName string,
+	key string,
+	zipFile []byte,
+	opts ...LambdaOption,
+) (err error) {
+	err = NewS3Bucket(ctx, bucketName)
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeBucketAlreadyOwnedByYou ||
+		aerr.Code() == s3.ErrCodeBucketAlreadyExists) {
+		err = nil
+	}
+
+	if err == nil {
+		logInfo("Uploading lambda package", F("bucket", bucketName), F("key", key))
+		_, err = services.S3Client().PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(zipFile),
+		})
+	}
+
+	if err == nil {
+		input := newFunctionInput(functionName, "handler.handler", lambda.RuntimePython36)
+		input.Code = &lambda.FunctionCode{
+			S3Bucket: aws.String(bucketName),
+			S3Key:    aws.String(key),
+		}
+		err = createFunction(ctx, services.LambdaClient(), input, opts)
+	}
+
+	return err
+}
@@ -0,0 +1,162 @@
+package lokalstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// pythonIndent indents the handler body
+const pythonIndent = "    "
+
+// PythonModule builds the source of a handler.py module defining handler(event, context)
+type PythonModule struct {
+	imports     []string
+	definitions []string
+	body        []string
+}
+
+// NewPythonModule returns an empty module whose handler returns None
+func NewPythonModule() *PythonModule {
+	return &PythonModule{}
+}
+
+// Import imports the modules, given by name ("json") or as a full statement
+// ("from decimal import Decimal"). Every module is imported once.
+func (m *PythonModule) Import(modules ...string) *PythonModule {
+	for _, module := range modules {
+		if !strings.HasPrefix(module, "import ") && !strings.HasPrefix(module, "from ") {
+			module = "import " + module
+		}
+		if !contains(m.imports, module) {
+			m.imports = append(m.imports, module)
+		}
+	}
+	return m
+}
+
+// Define adds module level code, e.g. a class or a helper function, after the imports
+func (m *PythonModule) Define(code string) *PythonModule {
+	m.definitions = append(m.definitions, dedent(code))
+	return m
+}
+
+// Body appends code to the handler body. The code may span several lines, its
+// common indentation is removed before it is indented into the handler.
+func (m *PythonModule) Body(code string) *PythonModule {
+	m.body = append(m.body, strings.Split(dedent(code), "\n")...)
+	return m
+}
+
+// Source returns the module source
+func (m *PythonModule) Source() string {
+	var b strings.Builder
+
+	for _, imp := range m.imports {
+		b.WriteString(imp + "\n")
+	}
+	// Top level definitions are separated by two blank lines
+	for _, def := range append(m.definitions, "def handler(event, context):") {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(def + "\n")
+	}
+
+	body := m.body
+	if len(strings.TrimSpace(strings.Join(body, ""))) == 0 {
+		body = []string{"pass"}
+	}
+	for _, line := range body {
+		if strings.TrimSpace(line) == "" {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(pythonIndent + line + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// dedent removes the indentation common to every non blank line and the surrounding blank lines
+func dedent(code string) string {
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, prefix), " \t")
+	}
+
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// pyString quotes s as a Python string literal
+func pyString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// PythonEcho returns a module whose handler returns the event
+func PythonEcho() *PythonModule {
+	return NewPythonModule().Body("return event")
+}
+
+// PythonRaise returns a module whose handler raises an exception of the given type,
+// reported as the errorType of the invocation
+func PythonRaise(errorType string, message string) *PythonModule {
+	return NewPythonModule().
+		Define(fmt.Sprintf("class %s(Exception):\n    pass", errorType)).
+		Body(fmt.Sprintf("raise %s(%s)", errorType, pyString(message)))
+}
+
+// PythonSleep returns a module whose handler sleeps for the given seconds and returns the event
+func PythonSleep(seconds float64) *PythonModule {
+	return NewPythonModule().
+		Import("time").
+		Body(fmt.Sprintf("time.sleep(%g)\nreturn event", seconds))
+}
+
+// PythonForwardToSQS returns a module whose handler sends the event, as JSON, to
+// the queue with the given URL and returns the event
+func PythonForwardToSQS(queueURL string) *PythonModule {
+	return NewPythonModule().
+		Import("json", "os", "boto3").
+		Body(fmt.Sprintf(`
+			endpoint = "http://%%s:%%s" %% (os.environ.get("LOCALSTACK_HOSTNAME", "localhost"), os.environ.get("EDGE_PORT", "4566"))
+			sqs = boto3.client("sqs", endpoint_url=endpoint)
+			sqs.send_message(QueueUrl=%s, MessageBody=json.dumps(event))
+			return event
+		`, pyString(queueURL)))
+}
+
+// NewPythonLambda deploys the module as handler.py to localstack, e.g.
+//
+//	NewPythonLambda(ctx, "downstream", PythonEcho())
+func NewPythonLambda(
+	ctx context.Context,
+	functionName string,
+	module *PythonModule,
+	opts ...LambdaOption,
+) (err error) {
+	var zipFile []byte
+	if zipFile, err = ZipFiles(map[string][]byte{"handler.py": []byte(module.Source())}); err == nil {
+		err = NewLambdaFromZip(ctx, functionName, zipFile, opts...)
+	}
+
+	return err
+}
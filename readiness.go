@@ -0,0 +1,152 @@
+package lokalstack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dc "github.com/ory/dockertest/docker"
+)
+
+// Backoff is the delay policy between the attempts of a readiness probe. A
+// Multiplier below 1 keeps the delay constant, a zero Max leaves it uncapped.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// next returns the delay following the given one
+func (b Backoff) next(delay time.Duration) time.Duration {
+	if b.Multiplier > 1 {
+		delay = time.Duration(float64(delay) * b.Multiplier)
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// ServiceReport describes how a service became ready during startup
+type ServiceReport struct {
+	Service     string
+	Attempts    int
+	Ready       bool
+	TimeToReady time.Duration
+	LastError   error
+}
+
+// StartupReport describes how the services of a stack became ready during startup
+type StartupReport struct {
+	Services []ServiceReport
+	Duration time.Duration
+}
+
+func (r *StartupReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "SERVICE\tREADY\tATTEMPTS\tTIME TO READY\tLAST ERROR")
+	for _, sr := range r.Services {
+		lastErr := ""
+		if sr.LastError != nil {
+			lastErr = strings.SplitN(sr.LastError.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(w, "%s\t%t\t%d\t%s\t%s\n", sr.Service, sr.Ready, sr.Attempts, sr.TimeToReady, lastErr)
+	}
+	_ = w.Flush()
+
+	return buf.String()
+}
+
+// failed returns the last error of each service that did not become ready
+func (r *StartupReport) failed() map[string]error {
+	res := map[string]error{}
+	for _, sr := range r.Services {
+		if !sr.Ready {
+			res[sr.Service] = sr.LastError
+		}
+	}
+	return res
+}
+
+// StartupError is returned when the services of a stack did not become ready in time.
+// It carries the startup report and the tail of the container logs.
+type StartupError struct {
+	Report *StartupReport
+	Logs   string
+	Err    error
+}
+
+func (e *StartupError) Error() string {
+	msg := fmt.Sprintf("localstack not ready after %s: %v\n%s", e.Report.Duration, e.Err, e.Report)
+	if e.Logs != "" {
+		msg += "container logs:\n" + e.Logs
+	}
+	return msg
+}
+
+// Cause returns the underlying ReadinessError
+func (e *StartupError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying ReadinessError
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+// Report returns how the services of the stack became ready during startup
+func (s *Stack) Report() *StartupReport {
+	return s.report
+}
+
+// waitService probes a service until it is ready or the context is done
+func (s *Stack) waitService(ctx context.Context, sr *ServiceReport, probe Probe, start time.Time) {
+	delay := s.opts.Backoff.Initial
+	for {
+		sr.Attempts++
+
+		probeCtx, cancel := context.WithTimeout(ctx, s.opts.ProbeTimeout)
+		sr.LastError = probe(probeCtx, s)
+		cancel()
+
+		if sr.LastError == nil {
+			sr.Ready = true
+			sr.TimeToReady = time.Since(start)
+			return
+		}
+
+		s.log(DebugLevel, "Service not ready yet",
+			F("service", sr.Service), F("attempt", sr.Attempts), F("error", sr.LastError))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+			delay = s.opts.Backoff.next(delay)
+		}
+	}
+}
+
+// tailLogs returns the last lines written by the container, if the stack owns one
+func (s *Stack) tailLogs(lines int) string {
+	if s.resource == nil || s.pool == nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	_ = s.pool.Client.Logs(dc.LogsOptions{
+		Container:    s.resource.Container.ID,
+		OutputStream: &buf,
+		ErrorStream:  &buf,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         fmt.Sprint(lines),
+	})
+
+	return buf.String()
+}
@@ -0,0 +1,174 @@
+package lokalstack
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+)
+
+const (
+	// LabelManaged marks containers started by lokalstack
+	LabelManaged = "lokalstack.managed"
+	// LabelOwnerPID holds the process id of the test binary that started the container
+	LabelOwnerPID = "lokalstack.owner.pid"
+	// LabelOwnerHost holds the host name of the test binary that started the container
+	LabelOwnerHost = "lokalstack.owner.host"
+	// LabelStarted holds the time the container was started, in RFC3339 format
+	LabelStarted = "lokalstack.started"
+	// LabelSession holds an id unique to the test binary that started the container
+	LabelSession = "lokalstack.session"
+)
+
+var (
+	// sessionID identifies the containers started by this process
+	sessionID = uuid.New().String() // nolint:gochecknoglobals
+
+	// liveStacks holds the stacks owning a container, purged when the process is interrupted
+	liveStacks   = map[*Stack]struct{}{} // nolint:gochecknoglobals
+	liveStacksMu sync.Mutex              // nolint:gochecknoglobals
+	signalsOnce  sync.Once               // nolint:gochecknoglobals
+)
+
+// ownerLabels returns the labels identifying this process as the owner of a container
+func ownerLabels(labels map[string]string) map[string]string {
+	host, _ := os.Hostname()
+
+	res := map[string]string{
+		LabelManaged:   "true",
+		LabelOwnerPID:  strconv.Itoa(os.Getpid()),
+		LabelOwnerHost: host,
+		LabelStarted:   time.Now().UTC().Format(time.RFC3339),
+		LabelSession:   sessionID,
+	}
+	for k, v := range labels {
+		res[k] = v
+	}
+
+	return res
+}
+
+// track registers a stack owning a container so it is purged on SIGINT and SIGTERM
+func track(s *Stack) {
+	liveStacksMu.Lock()
+	liveStacks[s] = struct{}{}
+	liveStacksMu.Unlock()
+
+	signalsOnce.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			sig := <-c
+			logWarn("Received signal, removing localstack containers", F("signal", sig))
+
+			liveStacksMu.Lock()
+			for s := range liveStacks {
+				if s.resource != nil {
+					_ = s.pool.Purge(s.resource)
+				}
+			}
+			liveStacksMu.Unlock()
+
+			// Let the signal take its default course, so that the process ends as it
+			// would have without the handler
+			signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+			if err := raise(sig); err != nil {
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			}
+		}()
+	})
+}
+
+// raise sends the signal to the current process
+func raise(sig os.Signal) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	return err
+}
+
+// untrack removes a stack whose container is gone
+func untrack(s *Stack) {
+	liveStacksMu.Lock()
+	delete(liveStacks, s)
+	liveStacksMu.Unlock()
+}
+
+// processAlive reports whether a process with the given id runs on this host
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess only succeeds for running processes on windows
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// orphaned reports whether the container lost its owner or outlived the ttl.
+// Reusable containers are never orphaned, ReapIdle removes them once idle.
+func orphaned(labels map[string]string, host string, ttl time.Duration) bool {
+	if labels[LabelReuse] == "true" {
+		return false
+	}
+
+	if ttl > 0 {
+		if started, err := time.Parse(time.RFC3339, labels[LabelStarted]); err == nil && time.Since(started) > ttl {
+			return true
+		}
+	}
+
+	pid, err := strconv.Atoi(labels[LabelOwnerPID])
+	return labels[LabelOwnerHost] == host && err == nil && !processAlive(pid)
+}
+
+// Reap removes the containers started by lokalstack whose owning process is gone,
+// or that are older than ttl when ttl is positive, and returns their names.
+// Reusable containers are left to ReapIdle.
+func Reap(ctx context.Context, ttl time.Duration) (removed []string, err error) {
+	var pool *dockertest.Pool
+	var containers []dc.APIContainers
+
+	host, _ := os.Hostname()
+
+	if pool, err = dockertest.NewPool(""); err == nil {
+		containers, err = pool.Client.ListContainers(dc.ListContainersOptions{
+			All:     true,
+			Filters: map[string][]string{"label": {LabelManaged + "=true"}},
+			Context: ctx,
+		})
+	}
+
+	for i := 0; err == nil && i < len(containers); i++ {
+		c := containers[i]
+		if !orphaned(c.Labels, host, ttl) {
+			continue
+		}
+
+		name := strings.TrimPrefix(c.Names[0], "/")
+		logInfo("Removing orphaned localstack container", F("container", name))
+		if err = pool.Client.RemoveContainer(dc.RemoveContainerOptions{
+			ID:            c.ID,
+			Force:         true,
+			RemoveVolumes: true,
+			Context:       ctx,
+		}); err == nil {
+			removed = append(removed, name)
+		}
+	}
+
+	return removed, err
+}
@@ -0,0 +1,96 @@
+package lokalstack_test
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	. "github.com/kraneware/lokalstack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reaper", func() {
+	var host string
+	var deadPID string
+
+	BeforeEach(func() {
+		var err error
+		host, err = os.Hostname()
+		Expect(err).Should(BeNil())
+
+		cmd := exec.Command("go", "version")
+		Expect(cmd.Run()).Should(BeNil())
+		deadPID = strconv.Itoa(cmd.Process.Pid)
+	})
+
+	Context("Owner labels", func() {
+		It("should label containers with the current process and the given labels", func() {
+			labels := OwnerLabels(map[string]string{LabelReuse: "true"})
+
+			Expect(labels).Should(HaveKeyWithValue(LabelManaged, "true"))
+			Expect(labels).Should(HaveKeyWithValue(LabelOwnerPID, strconv.Itoa(os.Getpid())))
+			Expect(labels).Should(HaveKeyWithValue(LabelOwnerHost, host))
+			Expect(labels).Should(HaveKeyWithValue(LabelReuse, "true"))
+			Expect(labels[LabelSession]).ShouldNot(BeEmpty())
+
+			started, err := time.Parse(time.RFC3339, labels[LabelStarted])
+			Expect(err).Should(BeNil())
+			Expect(started).Should(BeTemporally("~", time.Now(), 2*time.Second))
+		})
+	})
+	Context("Orphans", func() {
+		It("should reap containers whose owner process is gone", func() {
+			labels := OwnerLabels(map[string]string{LabelOwnerPID: deadPID})
+			Expect(Orphaned(labels, host, 0)).Should(BeTrue())
+			Expect(Orphaned(OwnerLabels(nil), host, 0)).Should(BeFalse())
+		})
+		It("should leave containers owned by another host", func() {
+			labels := OwnerLabels(map[string]string{LabelOwnerPID: deadPID})
+			Expect(Orphaned(labels, "another-"+host, 0)).Should(BeFalse())
+		})
+		It("should reap containers older than the ttl", func() {
+			labels := OwnerLabels(map[string]string{
+				LabelOwnerHost: "another-" + host,
+				LabelStarted:   time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+			})
+			Expect(Orphaned(labels, host, time.Hour)).Should(BeTrue())
+			Expect(Orphaned(labels, host, 3*time.Hour)).Should(BeFalse())
+			Expect(Orphaned(labels, host, 0)).Should(BeFalse())
+		})
+		It("should leave reusable containers to ReapIdle", func() {
+			labels := OwnerLabels(map[string]string{
+				LabelOwnerPID: deadPID,
+				LabelStarted:  time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+				LabelReuse:    "true",
+			})
+			Expect(Orphaned(labels, host, time.Hour)).Should(BeFalse())
+		})
+	})
+	Context("Idle containers", func() {
+		It("should measure idleness from the start of a container no stack used yet", func() {
+			name := "lokalstack-unused-" + strconv.Itoa(os.Getpid())
+			started := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+
+			last, ok := LastUsed(name, map[string]string{LabelStarted: started.Format(time.RFC3339)})
+			Expect(ok).Should(BeTrue())
+			Expect(last).Should(BeTemporally("==", started))
+
+			_, ok = LastUsed(name, nil)
+			Expect(ok).Should(BeFalse())
+		})
+		It("should prefer the last recorded use of a container", func() {
+			name := "lokalstack-used-" + strconv.Itoa(os.Getpid())
+			Expect(TouchLastUsed(name)).Should(BeNil())
+			defer os.Remove(LastUsedPath(name))
+
+			last, ok := LastUsed(name, map[string]string{
+				LabelStarted: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+			})
+			Expect(ok).Should(BeTrue())
+			Expect(last).Should(BeTemporally("~", time.Now(), 2*time.Second))
+		})
+	})
+})
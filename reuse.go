@@ -0,0 +1,273 @@
+package lokalstack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LabelReuse marks containers that may be reused across test binaries
+	LabelReuse = "lokalstack.reuse"
+	// LabelConfig holds the hash of the settings a reusable container was started with
+	LabelConfig = "lokalstack.config"
+
+	// lastUsedRefresh is how often a stack on a reusable container records that it still uses it
+	lastUsedRefresh = time.Minute
+)
+
+// invalidNameChars matches characters not allowed in every AWS resource name
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`) // nolint:gochecknoglobals
+
+// configHash hashes the settings that make two containers interchangeable
+func configHash(o *Options, mode EndpointMode) string {
+	env := append([]string{}, o.Env...)
+	sort.Strings(env)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%t\n%d\n%d\n", o.image(), o.Privileged, o.PortMode, mode)
+	for _, v := range env {
+		fmt.Fprintln(h, v)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// newNamespace returns a prefix for resource names unique to this test binary
+func newNamespace() string {
+	dir, _ := os.Getwd()
+	base := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(filepath.Base(dir)), "-"), "-")
+	return fmt.Sprintf("%s-%d", base, os.Getpid())
+}
+
+// lastUsedPath returns the file whose modification time records when a reusable container was last used
+func lastUsedPath(name string) string {
+	return filepath.Join(os.TempDir(), "lokalstack", name+".last-used")
+}
+
+func touchLastUsed(name string) (err error) {
+	path := lastUsedPath(name)
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+		err = ioutil.WriteFile(path, []byte(time.Now().Format(time.RFC3339)), 0o644)
+	}
+
+	return err
+}
+
+// lastUsed returns when the reusable container with the given name and labels was
+// last used, the time it was started when no stack recorded its use yet
+func lastUsed(name string, labels map[string]string) (time.Time, bool) {
+	if info, err := os.Stat(lastUsedPath(name)); err == nil {
+		return info.ModTime(), true
+	}
+	started, err := time.Parse(time.RFC3339, labels[LabelStarted])
+	return started, err == nil
+}
+
+// keepUsing records that the stack uses its reusable container until it is closed,
+// so that ReapIdle does not remove the container while a long suite runs
+func (s *Stack) keepUsing() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	name := s.name
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(lastUsedRefresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := touchLastUsed(name); err != nil {
+					s.log(WarnLevel, "Could not record the use of the localstack container", F("container", name), F("error", err))
+				}
+			}
+		}
+	}()
+
+	s.stopUsing = func() {
+		cancel()
+		<-done
+	}
+}
+
+// stopUsingContainer stops recording the use of the reusable container, if any
+func (s *Stack) stopUsingContainer() {
+	if s.stopUsing != nil {
+		s.stopUsing()
+		s.stopUsing = nil
+	}
+}
+
+// reuse initializes the stack on the reusable container matching its settings,
+// starting that container when there is none. Only a container that exited or
+// died is replaced: a running one may be in use by other test binaries, so a
+// failure to initialize on it is returned instead.
+func (s *Stack) reuse(ctx context.Context) (err error) {
+	hash := configHash(s.opts, s.endpointMode)
+	name := "lokalstack-" + hash
+
+	// Recorded before starting, ReapIdle must not remove a container still booting
+	if err = touchLastUsed(name); err != nil {
+		return err
+	}
+
+	var c *dc.Container
+	if c, err = s.reusableContainer(ctx, name); err == nil && c == nil {
+		s.log(InfoLevel, "No localstack container to reuse, starting one", F("container", name))
+
+		err = s.run(ctx, name, map[string]string{
+			LabelReuse:  "true",
+			LabelConfig: hash,
+		})
+
+		if errors.Cause(err) == dc.ErrContainerAlreadyExists {
+			// Another test binary created the container in the meantime
+			if c, err = s.reusableContainer(ctx, name); err == nil && c == nil {
+				err = errors.Errorf("container %s was removed while starting", name)
+			}
+		}
+	}
+
+	if err == nil && c != nil {
+		err = s.reuseExisting(ctx, name, c)
+	}
+
+	if err == nil {
+		s.reused = true
+		s.name = name
+		s.namespace = newNamespace()
+		if err = touchLastUsed(name); err == nil {
+			s.keepUsing()
+		}
+	}
+
+	return err
+}
+
+// reusableContainer returns the container with the given name once it runs, or nil
+// when there is none. A container being created, restarted or removed by another
+// test binary is waited for up to the readiness timeout, one that exited or died
+// is removed so that it can be replaced.
+func (s *Stack) reusableContainer(ctx context.Context, name string) (c *dc.Container, err error) {
+	waitCtx, cancel := context.WithTimeout(ctx, s.opts.ReadyTimeout)
+	defer cancel()
+
+	err = poll(waitCtx, func(ctx context.Context) (done bool, err error) {
+		if c, err = s.pool.Client.InspectContainerWithContext(name, ctx); err != nil {
+			_, missing := err.(*dc.NoSuchContainer)
+			return missing, ignoreMissing(err)
+		}
+
+		switch state := c.State.StateString(); {
+		case state == "running" && !c.State.RemovalInProgress:
+			return true, nil
+		case state == "created", state == "restarting", c.State.RemovalInProgress:
+			s.log(DebugLevel, "Waiting for localstack container", F("container", name), F("state", state))
+			return false, nil
+		case state == "exited", state == "dead":
+			s.log(InfoLevel, "Removing stopped localstack container", F("container", name), F("state", state))
+			c = nil
+			return true, ignoreMissing(s.pool.Client.RemoveContainer(dc.RemoveContainerOptions{
+				ID:            name,
+				RemoveVolumes: true,
+				Context:       ctx,
+			}))
+		default:
+			return false, errors.Errorf("container %s is %s", name, state)
+		}
+	})
+
+	return c, errors.Wrapf(err, "waiting for container %s", name)
+}
+
+// ignoreMissing drops the error of a container that does not exist
+func ignoreMissing(err error) error {
+	if _, ok := err.(*dc.NoSuchContainer); ok {
+		return nil
+	}
+	return err
+}
+
+// reuseExisting initializes the stack on the running container with the given name
+func (s *Stack) reuseExisting(ctx context.Context, name string, c *dc.Container) (err error) {
+	s.log(InfoLevel, "Reusing localstack container", F("container", name))
+
+	s.resource = &dockertest.Resource{Container: c}
+	if err = s.initContainer(ctx); err != nil {
+		s.resource = nil
+	}
+
+	return err
+}
+
+// ResourceName prefixes the given name with the namespace of the stack. Stacks on
+// a reused container have a namespace unique to the test binary, so that packages
+// sharing the container don't clash. Other stacks return the name unchanged.
+func (s *Stack) ResourceName(name string) string {
+	if s.namespace == "" {
+		return name
+	}
+	return s.namespace + "-" + name
+}
+
+// ResourceName prefixes the given name with the namespace of the stack started by StartContainer
+func ResourceName(name string) string {
+	if defaultStack == nil {
+		return name
+	}
+	return defaultStack.ResourceName(name)
+}
+
+// ReapIdle removes the reusable containers that have not been used for the given
+// duration and returns their names. A container no stack recorded its use of yet
+// is idle from the time it was started.
+func ReapIdle(ctx context.Context, idle time.Duration) (removed []string, err error) {
+	var pool *dockertest.Pool
+	var containers []dc.APIContainers
+
+	if pool, err = dockertest.NewPool(""); err == nil {
+		containers, err = pool.Client.ListContainers(dc.ListContainersOptions{
+			All:     true,
+			Filters: map[string][]string{"label": {LabelReuse + "=true"}},
+			Context: ctx,
+		})
+	}
+
+	for i := 0; err == nil && i < len(containers); i++ {
+		name := strings.TrimPrefix(containers[i].Names[0], "/")
+
+		if last, ok := lastUsed(name, containers[i].Labels); ok && time.Since(last) < idle {
+			continue
+		}
+
+		logInfo("Removing idle localstack container", F("container", name))
+		if err = pool.Client.RemoveContainer(dc.RemoveContainerOptions{
+			ID:            containers[i].ID,
+			Force:         true,
+			RemoveVolumes: true,
+			Context:       ctx,
+		}); err == nil {
+			_ = os.Remove(lastUsedPath(name))
+			removed = append(removed, name)
+		}
+	}
+
+	return removed, err
+}
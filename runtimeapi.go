@@ -0,0 +1,362 @@
+package lokalstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// runtimeAPIPrefix prefixes the paths of the Lambda Runtime API
+	runtimeAPIPrefix = "/2018-06-01/runtime/"
+
+	// EnvRuntimeAPI is the variable pointing a custom runtime at the Runtime API
+	EnvRuntimeAPI = "AWS_LAMBDA_RUNTIME_API"
+)
+
+// RuntimeInvocation is an event pushed to the Runtime API together with the
+// response or the error the runtime posted for it
+type RuntimeInvocation struct {
+	RequestID   string
+	FunctionArn string
+	TraceID     string
+	Deadline    time.Time
+	Payload     []byte
+	Response    []byte
+	Error       *LambdaError
+
+	done chan struct{}
+}
+
+// Done is closed once the runtime posted the response or the error of the invocation
+func (i *RuntimeInvocation) Done() <-chan struct{} {
+	return i.done
+}
+
+// Wait waits until the runtime posted the response or the error of the invocation
+func (i *RuntimeInvocation) Wait(ctx context.Context) error {
+	select {
+	case <-i.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "waiting for invocation %s", i.RequestID)
+	}
+}
+
+// RuntimeAPI is a local Lambda Runtime API, used to test the bootstrap loop of
+// custom runtimes such as provided.al2 Go binaries. Events pushed to it are handed
+// out on /runtime/invocation/next and the responses and errors posted back are recorded.
+type RuntimeAPI struct {
+	// Address is the host:port of the Runtime API, the value of AWS_LAMBDA_RUNTIME_API
+	Address string
+	// FunctionName is the name of the function the runtime serves
+	FunctionName string
+	// Timeout sets the deadline of the invocations pushed afterwards
+	Timeout time.Duration
+
+	listener    net.Listener
+	server      *http.Server
+	pending     chan *RuntimeInvocation
+	invocations []*RuntimeInvocation
+	byID        map[string]*RuntimeInvocation
+	initError   *LambdaError
+	mu          sync.Mutex
+	restoreEnv  map[string]*string
+}
+
+// NewRuntimeAPI starts serving the Runtime API for the named function on a free
+// port of the loopback interface
+func NewRuntimeAPI(functionName string) (r *RuntimeAPI, err error) {
+	r = &RuntimeAPI{
+		FunctionName: functionName,
+		Timeout:      DefaultLambdaTimeout * time.Second,
+		pending:      make(chan *RuntimeInvocation, 100),
+		byID:         map[string]*RuntimeInvocation{},
+	}
+
+	if r.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, errors.Wrap(err, "starting runtime api")
+	}
+
+	r.Address = r.listener.Addr().String()
+	r.server = &http.Server{Handler: http.HandlerFunc(r.serve)}
+	go func() {
+		_ = r.server.Serve(r.listener)
+	}()
+
+	logInfo("Started lambda runtime api", F("address", r.Address), F("function", functionName))
+
+	return r, nil
+}
+
+// Env returns the variables a runtime process needs to reach the Runtime API, e.g.
+//
+//	cmd.Env = append(os.Environ(), runtime.Env()...)
+func (r *RuntimeAPI) Env() []string {
+	vars := r.env()
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (r *RuntimeAPI) env() map[string]string {
+	return map[string]string{
+		EnvRuntimeAPI:                     r.Address,
+		"AWS_LAMBDA_FUNCTION_NAME":        r.FunctionName,
+		"AWS_LAMBDA_FUNCTION_VERSION":     "$LATEST",
+		"AWS_LAMBDA_FUNCTION_MEMORY_SIZE": strconv.Itoa(DefaultLambdaMemory),
+		"AWS_REGION":                      currentRegion(),
+	}
+}
+
+// Setenv sets the variables returned by Env in the current process, for runtimes
+// started in process. Close restores the previous values.
+func (r *RuntimeAPI) Setenv() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.restoreEnv == nil {
+		r.restoreEnv = map[string]*string{}
+	}
+	for key, value := range r.env() {
+		if _, saved := r.restoreEnv[key]; !saved {
+			if previous, ok := os.LookupEnv(key); ok {
+				r.restoreEnv[key] = &previous
+			} else {
+				r.restoreEnv[key] = nil
+			}
+		}
+		if err = os.Setenv(key, value); err != nil {
+			break
+		}
+	}
+
+	return err
+}
+
+// Push queues the event, marshalled to JSON, for the runtime. It blocks while 100
+// events wait for a runtime to poll them, until the context is done.
+func (r *RuntimeAPI) Push(ctx context.Context, event interface{}) (i *RuntimeInvocation, err error) {
+	var payload []byte
+	if payload, err = eventPayload(event); err != nil {
+		return nil, errors.Wrap(err, "marshalling lambda event")
+	}
+
+	i = &RuntimeInvocation{
+		RequestID:   uuid.New().String(),
+		FunctionArn: fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", currentRegion(), emulatorAccount, r.FunctionName),
+		TraceID:     fmt.Sprintf("Root=%s;Parent=%s;Sampled=1", xray.NewTraceID(), xray.NewSegmentID()),
+		Deadline:    time.Now().Add(r.Timeout),
+		Payload:     payload,
+		done:        make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.invocations = append(r.invocations, i)
+	r.byID[i.RequestID] = i
+	r.mu.Unlock()
+
+	select {
+	case r.pending <- i:
+		return i, nil
+	case <-ctx.Done():
+		r.forget(i)
+		return nil, errors.Wrap(ctx.Err(), "queueing lambda event")
+	}
+}
+
+// forget drops an invocation that was never queued
+func (r *RuntimeAPI) forget(i *RuntimeInvocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.byID, i.RequestID)
+	for n, v := range r.invocations {
+		if v == i {
+			r.invocations = append(r.invocations[:n], r.invocations[n+1:]...)
+			break
+		}
+	}
+}
+
+// Invoke pushes the event and waits for the runtime to handle it
+func (r *RuntimeAPI) Invoke(ctx context.Context, event interface{}) (res *InvokeResult, err error) {
+	start := time.Now()
+
+	var i *RuntimeInvocation
+	if i, err = r.Push(ctx, event); err == nil {
+		err = i.Wait(ctx)
+	}
+
+	if err == nil {
+		res = &InvokeResult{
+			StatusCode:      http.StatusOK,
+			Payload:         i.Response,
+			Error:           i.Error,
+			ExecutedVersion: "$LATEST",
+			Duration:        time.Since(start),
+		}
+		if i.Error != nil {
+			res.FunctionError = "Unhandled"
+			res.Payload, _ = json.Marshal(i.Error)
+		}
+	}
+
+	return res, err
+}
+
+// Invocations returns the invocations pushed so far, in order
+func (r *RuntimeAPI) Invocations() []*RuntimeInvocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RuntimeInvocation(nil), r.invocations...)
+}
+
+// InitError returns the error the runtime posted while initializing, if any
+func (r *RuntimeAPI) InitError() *LambdaError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.initError
+}
+
+// Close stops serving the Runtime API and restores the variables changed by Setenv
+func (r *RuntimeAPI) Close() (err error) {
+	err = r.server.Close()
+
+	r.mu.Lock()
+	for key, previous := range r.restoreEnv {
+		if previous != nil {
+			_ = os.Setenv(key, *previous)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+	r.restoreEnv = nil
+	r.mu.Unlock()
+
+	logInfo("Stopped lambda runtime api", F("address", r.Address))
+
+	return err
+}
+
+func (r *RuntimeAPI) serve(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, runtimeAPIPrefix)
+
+	switch {
+	case req.Method == http.MethodGet && path == "invocation/next":
+		r.serveNext(w, req)
+	case req.Method == http.MethodPost && path == "init/error":
+		r.serveInitError(w, req)
+	case req.Method == http.MethodPost && strings.HasPrefix(path, "invocation/"):
+		parts := strings.Split(strings.TrimPrefix(path, "invocation/"), "/")
+		if len(parts) == 2 && (parts[1] == "response" || parts[1] == "error") {
+			r.serveResult(w, req, parts[0], parts[1] == "error")
+		} else {
+			writeRuntimeError(w, http.StatusNotFound, "InvalidRequest", "unsupported request "+req.Method+" "+req.URL.Path)
+		}
+	default:
+		writeRuntimeError(w, http.StatusNotFound, "InvalidRequest", "unsupported request "+req.Method+" "+req.URL.Path)
+	}
+}
+
+// serveNext hands out the next pushed event, blocking until there is one
+func (r *RuntimeAPI) serveNext(w http.ResponseWriter, req *http.Request) {
+	select {
+	case i := <-r.pending:
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", i.RequestID)
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(i.Deadline.UnixNano()/int64(time.Millisecond), 10))
+		w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", i.FunctionArn)
+		w.Header().Set("Lambda-Runtime-Trace-Id", i.TraceID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(i.Payload)
+	case <-req.Context().Done():
+	}
+}
+
+// serveResult records the response or the error posted for an invocation
+func (r *RuntimeAPI) serveResult(w http.ResponseWriter, req *http.Request, requestID string, failed bool) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.byID[requestID]
+	if !ok {
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidRequestID", "unknown request id "+requestID)
+		return
+	}
+	select {
+	case <-i.done:
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidStateTransition", "invocation "+requestID+" already completed")
+		return
+	default:
+	}
+
+	if failed {
+		i.Error = runtimeError(req, body)
+	} else {
+		i.Response = body
+	}
+	close(i.done)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveInitError records the error posted by a runtime failing to initialize
+func (r *RuntimeAPI) serveInitError(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	r.mu.Lock()
+	r.initError = runtimeError(req, body)
+	r.mu.Unlock()
+
+	logWarn("Lambda runtime failed to initialize", F("function", r.FunctionName), F("error", r.initError))
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// runtimeError reads an error posted by a runtime, the error type defaults to
+// the Lambda-Runtime-Function-Error-Type header
+func runtimeError(req *http.Request, body []byte) *LambdaError {
+	e := &LambdaError{}
+	if err := json.Unmarshal(body, e); err != nil {
+		e.ErrorMessage = string(body)
+	}
+	if e.ErrorType == "" {
+		e.ErrorType = req.Header.Get("Lambda-Runtime-Function-Error-Type")
+	}
+	return e
+}
+
+// writeRuntimeError writes an error response of the Runtime API
+func writeRuntimeError(w http.ResponseWriter, status int, errorType string, message string) {
+	body, _ := json.Marshal(&LambdaError{ErrorType: errorType, ErrorMessage: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
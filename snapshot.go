@@ -0,0 +1,332 @@
+package lokalstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+// EnvUpdateGolden regenerates the golden files of the snapshots instead of comparing them
+const EnvUpdateGolden = "LOKALSTACK_UPDATE_GOLDEN"
+
+// TableSnapshot is the content of a table, as normalized Go values sorted by key.
+// Strings are kept as string, numbers as json.Number in their shortest decimal
+// form, e.g. 12.5 for 12.50, binaries as []byte and sets as sorted lists.
+type TableSnapshot struct {
+	Table string                   `json:"table"`
+	Keys  []string                 `json:"keys"`
+	Items []map[string]interface{} `json:"items"`
+}
+
+// SnapshotTable scans every item of the table into a snapshot
+func SnapshotTable(ctx context.Context, tableName string) (s *TableSnapshot, err error) {
+	client := services.DynamoDbClient()
+
+	var table *dynamodb.DescribeTableOutput
+	if table, err = client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}); err != nil {
+		return nil, errors.Wrapf(err, "describing table %s", tableName)
+	}
+
+	s = &TableSnapshot{Table: tableName, Items: []map[string]interface{}{}}
+	for _, key := range table.Table.KeySchema {
+		if aws.StringValue(key.KeyType) == dynamodb.KeyTypeHash {
+			s.Keys = append([]string{aws.StringValue(key.AttributeName)}, s.Keys...)
+		} else {
+			s.Keys = append(s.Keys, aws.StringValue(key.AttributeName))
+		}
+	}
+
+	if err = client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			s.Items = append(s.Items, normalizeItem(item))
+		}
+		return true
+	}); err != nil {
+		return nil, errors.Wrapf(err, "scanning table %s", tableName)
+	}
+
+	sort.SliceStable(s.Items, func(i, j int) bool {
+		for _, key := range s.Keys {
+			if c := compareValues(s.Items[i][key], s.Items[j][key]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	return s, nil
+}
+
+// ReadSnapshot reads a snapshot written by WriteFile
+func ReadSnapshot(path string) (s *TableSnapshot, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	if err = decoder.Decode(&s); err != nil {
+		return nil, errors.Wrapf(err, "reading snapshot %s", path)
+	}
+
+	// Golden files may be written by hand, e.g. 1.0 for the number 1
+	for i, item := range s.Items {
+		s.Items[i] = normalizeNumbers(item).(map[string]interface{})
+	}
+
+	return s, nil
+}
+
+// WriteFile writes the snapshot as indented JSON, creating the parent directories
+func (s *TableSnapshot) WriteFile(path string) (err error) {
+	var contents []byte
+	if contents, err = json.MarshalIndent(s, "", "  "); err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = ioutil.WriteFile(path, append(contents, '\n'), 0o644)
+		}
+	}
+
+	return err
+}
+
+// CompareGolden compares the snapshot with the golden file, failing with the diff
+// when they differ. With LOKALSTACK_UPDATE_GOLDEN=true the golden file is written instead.
+func (s *TableSnapshot) CompareGolden(path string) error {
+	if update, _ := strconv.ParseBool(os.Getenv(EnvUpdateGolden)); update {
+		logInfo("Updating golden snapshot", F("table", s.Table), F("path", path))
+		return s.WriteFile(path)
+	}
+
+	golden, err := ReadSnapshot(path)
+	if err != nil {
+		return errors.Wrapf(err, "set %s=true to create the golden file", EnvUpdateGolden)
+	}
+
+	if diff := s.Diff(golden); diff != "" {
+		return errors.Errorf("snapshot differs from %s, set %s=true to update it\n%s", path, EnvUpdateGolden, diff)
+	}
+
+	return nil
+}
+
+// Diff returns a readable diff of the items added, removed and changed since the
+// expected snapshot, one item per line with changed attributes indented below,
+// or an empty string when the snapshots hold the same items
+func (s *TableSnapshot) Diff(expected *TableSnapshot) string {
+	actualItems, actualOrder := s.itemsBy(s.Keys)
+	expectedItems, expectedOrder := expected.itemsBy(s.Keys)
+
+	var added, removed, changed []string
+	for _, key := range actualOrder {
+		item := actualItems[key]
+		if old, ok := expectedItems[key]; !ok {
+			added = append(added, "+ "+key+" "+canonical(item))
+		} else if changes := diffItem(old, item); len(changes) > 0 {
+			changed = append(changed, "~ "+key+"\n"+strings.Join(changes, "\n"))
+		}
+	}
+	for _, key := range expectedOrder {
+		if _, ok := actualItems[key]; !ok {
+			removed = append(removed, "- "+key+" "+canonical(expectedItems[key]))
+		}
+	}
+
+	if len(added)+len(removed)+len(changed) == 0 {
+		return ""
+	}
+
+	lines := []string{fmt.Sprintf("table %s: %d added, %d removed, %d changed items",
+		s.Table, len(added), len(removed), len(changed))}
+	lines = append(lines, added...)
+	lines = append(lines, removed...)
+	lines = append(lines, changed...)
+
+	return strings.Join(lines, "\n")
+}
+
+// itemsBy indexes the items by the values of the given key attributes, keeping their order
+func (s *TableSnapshot) itemsBy(keys []string) (items map[string]map[string]interface{}, order []string) {
+	items = make(map[string]map[string]interface{}, len(s.Items))
+	for _, item := range s.Items {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = key + "=" + canonical(item[key])
+		}
+		id := strings.Join(parts, " ")
+		items[id] = item
+		order = append(order, id)
+	}
+	return items, order
+}
+
+// diffItem lists the attributes added, removed and changed since the expected item
+func diffItem(expected map[string]interface{}, actual map[string]interface{}) (changes []string) {
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		old, hadOld := expected[name]
+		value, hasValue := actual[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, "    + "+name+": "+canonical(value))
+		case !hasValue:
+			changes = append(changes, "    - "+name+": "+canonical(old))
+		case canonical(old) != canonical(value):
+			changes = append(changes, "    "+name+": "+canonical(old)+" -> "+canonical(value))
+		}
+	}
+
+	return changes
+}
+
+// canonical renders a value as JSON, with sorted map keys and normalized numbers
+func canonical(v interface{}) string {
+	s, err := json.Marshal(normalizeNumbers(v))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(s)
+}
+
+// normalizeItem converts an item to Go values
+func normalizeItem(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	res := make(map[string]interface{}, len(item))
+	for name, value := range item {
+		res[name] = normalizeValue(value)
+	}
+	return res
+}
+
+// normalizeValue converts an attribute value to a Go value, sets are sorted
+func normalizeValue(av *dynamodb.AttributeValue) interface{} {
+	switch {
+	case av.S != nil:
+		return *av.S
+	case av.N != nil:
+		return normalizeNumber(json.Number(*av.N))
+	case av.B != nil:
+		return av.B
+	case av.BOOL != nil:
+		return *av.BOOL
+	case av.M != nil:
+		return normalizeItem(av.M)
+	case av.L != nil:
+		l := make([]interface{}, len(av.L))
+		for i, v := range av.L {
+			l[i] = normalizeValue(v)
+		}
+		return l
+	case av.SS != nil:
+		l := make([]interface{}, len(av.SS))
+		for i, v := range av.SS {
+			l[i] = *v
+		}
+		return sortedSet(l)
+	case av.NS != nil:
+		l := make([]interface{}, len(av.NS))
+		for i, v := range av.NS {
+			l[i] = normalizeNumber(json.Number(*v))
+		}
+		return sortedSet(l)
+	case av.BS != nil:
+		l := make([]interface{}, len(av.BS))
+		for i, v := range av.BS {
+			l[i] = v
+		}
+		return sortedSet(l)
+	default:
+		return nil
+	}
+}
+
+// normalizeNumber returns the shortest decimal form of a number, DynamoDB numbers
+// have up to 38 digits and fit a 256 bit float
+func normalizeNumber(n json.Number) json.Number {
+	f, _, err := big.ParseFloat(string(n), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return n
+	}
+	return json.Number(f.Text('f', -1))
+}
+
+// normalizeNumbers returns the value with every json.Number normalized, maps and
+// lists are copied
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return normalizeNumber(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = normalizeNumbers(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = normalizeNumbers(value)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func sortedSet(l []interface{}) []interface{} {
+	sort.Slice(l, func(i, j int) bool {
+		return compareValues(l[i], l[j]) < 0
+	})
+	return l
+}
+
+// compareValues orders numbers numerically, strings and binaries bytewise and
+// other values by their JSON rendering
+func compareValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case json.Number:
+		if b, ok := b.(json.Number); ok {
+			x, _, errA := big.ParseFloat(string(a), 10, 256, big.ToNearestEven)
+			y, _, errB := big.ParseFloat(string(b), 10, 256, big.ToNearestEven)
+			if errA == nil && errB == nil {
+				return x.Cmp(y)
+			}
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b)
+		}
+	}
+	return strings.Compare(canonical(a), canonical(b))
+}
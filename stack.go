@@ -0,0 +1,318 @@
+package lokalstack
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/kraneware/kws/config"
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+	"github.com/pkg/errors"
+)
+
+// Stack is a localstack container together with the endpoints, credentials
+// and region used to reach it. Several stacks may run side by side.
+type Stack struct {
+	Region      string
+	Credentials *credentials.Credentials
+	Endpoints   config.AwsEndpointSet
+
+	opts         *Options
+	endpointMode EndpointMode
+	pool         *dockertest.Pool
+	resource     *dockertest.Resource
+	session      *session.Session
+	attached     *url.URL
+	services     []string
+	reused       bool
+	name         string
+	namespace    string
+	report       *StartupReport
+	stopLogs     func()
+	stopUsing    func()
+}
+
+// Start starts a new localstack container, waits for it to accept requests
+// and builds out the base testing infrastructure
+func Start(ctx context.Context, opts ...Option) (s *Stack, err error) {
+	s = &Stack{opts: newOptions(opts...)}
+	s.log(InfoLevel, "Starting localstack container", F("image", s.opts.image()))
+
+	s.Region = s.opts.Region
+	s.Credentials = s.opts.Credentials
+	s.endpointMode = resolveEndpointMode(s.opts.EndpointMode, s.opts.Tag)
+
+	if err = s.opts.err; err == nil {
+		s.pool, err = dockertest.NewPool("")
+	}
+
+	if err == nil {
+		if s.opts.Reuse {
+			err = s.reuse(ctx)
+		} else {
+			err = s.run(ctx, "", nil)
+		}
+	}
+
+	return s, err
+}
+
+// run starts a new container with the given name and labels and initializes the stack on it
+func (s *Stack) run(ctx context.Context, name string, labels map[string]string) (err error) {
+	runOptions := &dockertest.RunOptions{
+		Name:       name,
+		Repository: s.opts.Repository,
+		Tag:        s.opts.imageTag(),
+		Env:        s.opts.Env,
+		Labels:     ownerLabels(labels),
+		Privileged: s.opts.Privileged,
+	}
+
+	ports := containerPorts(s.endpointMode)
+	if s.opts.PortMode == DynamicPorts {
+		// Docker publishes every exposed port on a free host port
+		runOptions.ExposedPorts = exposedPorts(ports...)
+	} else {
+		runOptions.PortBindings = createPortBindings(ports...)
+	}
+
+	if s.opts.Digest != "" {
+		if err = s.pullDigest(); err != nil {
+			return err
+		}
+	}
+
+	// Starting localstack docker container with port mappings
+	// Lambdas in golang require 'LAMBDA_EXECUTOR=docker'
+	if s.resource, err = s.pool.RunWithOptions(runOptions); err == nil {
+		// Reusable containers are meant to outlive the test binary
+		if s.opts.HandleSignals && labels[LabelReuse] != "true" {
+			track(s)
+		}
+		err = s.initContainer(ctx)
+	}
+
+	if err != nil && s.resource != nil {
+		// Other test binaries may already be using a reusable container
+		if labels[LabelReuse] != "true" {
+			_ = s.pool.Purge(s.resource)
+		}
+		untrack(s)
+		s.resource = nil
+	}
+
+	return err
+}
+
+// pullDigest pulls a digest pinned image, which Docker only pulls by its digest
+// while dockertest pulls by tag
+func (s *Stack) pullDigest() (err error) {
+	ref := s.opts.Repository + "@" + s.opts.Digest
+	if _, err = s.pool.Client.InspectImage(ref); err != nil {
+		s.log(InfoLevel, "Pulling localstack image", F("image", ref))
+		err = s.pool.Client.PullImage(dc.PullImageOptions{
+			Repository: s.opts.Repository,
+			Tag:        s.opts.Digest,
+		}, dc.AuthConfiguration{})
+	}
+
+	return errors.Wrapf(err, "pulling image %s", ref)
+}
+
+// initContainer reads the endpoints back from the container and initializes the stack
+func (s *Stack) initContainer(ctx context.Context) (err error) {
+	if s.opts.LogWriter != nil && s.stopLogs == nil {
+		s.streamLogsInBackground(s.opts.LogWriter)
+	}
+
+	if s.Endpoints, err = endpointSet(s.endpointMode, s.hostEndpoint); err == nil {
+		err = s.init(ctx)
+	}
+
+	if err != nil {
+		// A stack failing to start is not closed, stop writing to the log writer
+		s.stopStreamingLogs()
+	}
+
+	return err
+}
+
+// Attach connects to an already running localstack at the given endpoint, e.g.
+// http://localhost:4566, instead of starting a container. The stack waits for
+// the services to accept requests and builds out the base testing infrastructure.
+// Closing an attached stack leaves the localstack running.
+func Attach(ctx context.Context, endpoint string, opts ...Option) (s *Stack, err error) {
+	s = &Stack{opts: newOptions(opts...)}
+	s.log(InfoLevel, "Attaching to localstack", F("endpoint", endpoint))
+
+	s.Region = s.opts.Region
+	s.Credentials = s.opts.Credentials
+	s.endpointMode = s.opts.EndpointMode
+	if s.endpointMode == AutoEndpoints {
+		// The image version of a running localstack is unknown
+		s.endpointMode = EdgeEndpoints
+	}
+
+	if err = s.opts.err; err == nil {
+		s.attached, err = parseEndpoint(endpoint)
+	}
+
+	if err == nil {
+		s.Endpoints, err = endpointSet(s.endpointMode, s.hostEndpoint)
+	}
+
+	if err == nil {
+		err = s.init(ctx)
+	}
+
+	return s, err
+}
+
+// parseEndpoint parses the URL of a running localstack
+func parseEndpoint(endpoint string) (u *url.URL, err error) {
+	if u, err = url.Parse(endpoint); err == nil && (u.Scheme == "" || u.Host == "") {
+		err = errors.Errorf("invalid localstack endpoint %q", endpoint)
+	}
+
+	return u, err
+}
+
+// hostEndpoint returns the endpoint on which the given container port is reachable from the host
+func (s *Stack) hostEndpoint(port string) (endpoint string, err error) {
+	if s.attached != nil {
+		// The attached URL is the edge endpoint, e.g. behind a proxy on the default port
+		if port == EdgePort {
+			endpoint = s.attached.Scheme + "://" + s.attached.Host
+		} else {
+			endpoint = s.attached.Scheme + "://" + net.JoinHostPort(s.attached.Hostname(), port)
+		}
+	} else if s.opts.PortMode == DynamicPorts {
+		if hostPort := s.resource.GetHostPort(port + "/tcp"); hostPort != "" {
+			endpoint = "http://" + hostPort
+		} else {
+			err = errors.Errorf("container port %s is not published", port)
+		}
+	} else {
+		endpoint = "http://localhost:" + port
+	}
+
+	return endpoint, err
+}
+
+// init waits for the stack to accept requests and builds the base testing infrastructure
+func (s *Stack) init(ctx context.Context) (err error) {
+	s.session, err = session.NewSession(&aws.Config{
+		Region:      aws.String(s.Region),
+		Credentials: s.Credentials,
+	})
+
+	if err == nil {
+		s.services = s.readyServices()
+
+		// Ensuring container is ready to accept requests
+		if err = s.checkContainerReady(ctx); err == nil {
+			s.log(InfoLevel, "Started localstack container", F("duration", s.report.Duration))
+
+			err = s.buildTestingInfrastructure(ctx)
+		}
+	}
+
+	return err
+}
+
+// Use points the kws configuration at this stack, so that the services clients
+// and the package level helpers talk to it
+func (s *Stack) Use() {
+	s.log(InfoLevel, "Setting localstack region", F("region", s.Region))
+	config.Region = s.Region
+	config.Credentials = s.Credentials
+	config.Endpoints = s.Endpoints
+}
+
+// Close stops and removes the localstack container owned by the stack.
+// An attached or reused stack is only detached from, its localstack keeps running.
+func (s *Stack) Close() (err error) {
+	s.stopStreamingLogs()
+
+	if s.attached != nil {
+		s.log(InfoLevel, "Detaching from localstack", F("endpoint", s.attached.String()))
+		s.attached = nil
+	} else if s.reused {
+		s.log(InfoLevel, "Detaching from reusable localstack container", F("container", s.name))
+		s.stopUsingContainer()
+		err = touchLastUsed(s.name)
+		s.reused = false
+		s.resource = nil
+	} else if s.resource == nil {
+		err = errors.New("Container not started")
+	} else {
+		s.log(InfoLevel, "Stopping localstack container")
+		// Once tests are done, kill and remove the container
+		if err = s.pool.Retry(func() error {
+			err := s.pool.Purge(s.resource)
+			if err != nil {
+				return errors.New("could not stop localstack container")
+			}
+			return err
+		}); err == nil {
+			untrack(s)
+			s.resource = nil
+			s.log(InfoLevel, "Stopped localstack container")
+		}
+	}
+
+	return err
+}
+
+// log passes an entry to the logger of the stack
+func (s *Stack) log(level Level, msg string, fields ...Field) {
+	l := s.opts.Logger
+	if l == nil {
+		l = currentLogger()
+	}
+	l.Log(level, msg, fields...)
+}
+
+func (s *Stack) clientConfig(endpoint string) *aws.Config {
+	return aws.NewConfig().WithEndpoint(endpoint)
+}
+
+// DynamoDbClient returns a DynamoDB client bound to the stack
+func (s *Stack) DynamoDbClient() *dynamodb.DynamoDB {
+	return dynamodb.New(s.session, s.clientConfig(s.Endpoints.DynamoDB))
+}
+
+// LambdaClient returns a Lambda client bound to the stack
+func (s *Stack) LambdaClient() *lambda.Lambda {
+	return lambda.New(s.session, s.clientConfig(s.Endpoints.Lambda))
+}
+
+// SNSClient returns a SNS client bound to the stack
+func (s *Stack) SNSClient() *sns.SNS {
+	return sns.New(s.session, s.clientConfig(s.Endpoints.SNS))
+}
+
+// SQSClient returns a SQS client bound to the stack
+func (s *Stack) SQSClient() *sqs.SQS {
+	return sqs.New(s.session, s.clientConfig(s.Endpoints.SQS))
+}
+
+// S3Client returns a S3 client bound to the stack
+func (s *Stack) S3Client() *s3.S3 {
+	return s3.New(s.session, s.clientConfig(s.Endpoints.S3).WithS3ForcePathStyle(true))
+}
+
+// APIGWClient returns an API Gateway client bound to the stack
+func (s *Stack) APIGWClient() *apigateway.APIGateway {
+	return apigateway.New(s.session, s.clientConfig(s.Endpoints.APIGateway))
+}
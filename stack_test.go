@@ -0,0 +1,302 @@
+package lokalstack_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/kraneware/kws/config"
+	"github.com/kraneware/kws/services"
+	. "github.com/kraneware/lokalstack"
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stack", func() {
+	Context("Options", func() {
+		It("should split the image into repository, tag and digest", func() {
+			o := &Options{Tag: DefaultTag}
+			WithImage("host:5000/localstack/localstack")(o)
+			Expect(o.Repository).Should(Equal("host:5000/localstack/localstack"))
+			Expect(o.Tag).Should(Equal(DefaultTag))
+
+			WithImage("host:5000/localstack/localstack:0.12.5")(o)
+			Expect(o.Repository).Should(Equal("host:5000/localstack/localstack"))
+			Expect(o.Tag).Should(Equal("0.12.5"))
+			Expect(o.Digest).Should(BeEmpty())
+
+			WithImage("localstack/localstack:0.14.1@sha256:0123abcd")(o)
+			Expect(o.Repository).Should(Equal("localstack/localstack"))
+			Expect(o.Tag).Should(Equal("0.14.1"))
+			Expect(o.Digest).Should(Equal("sha256:0123abcd"))
+
+			o = &Options{Tag: DefaultTag}
+			WithImage("host:5000/localstack/localstack@sha256:0123abcd")(o)
+			Expect(o.Repository).Should(Equal("host:5000/localstack/localstack"))
+			Expect(o.Tag).Should(Equal(DefaultTag))
+			Expect(o.Digest).Should(Equal("sha256:0123abcd"))
+		})
+		It("should apply the environment on top of the options", func() {
+			env := map[string]string{
+				EnvImage:                     "mirror:5000/localstack:0.12.5",
+				EnvPrivileged:                "false",
+				EnvReuse:                     "1",
+				EnvServices:                  "dynamodb, sqs,",
+				EnvContainerPrefix + "DEBUG": "0",
+				EnvContainerPrefix + "OPTS":  "a=b",
+			}
+			for k, v := range env {
+				Expect(os.Setenv(k, v)).Should(BeNil())
+				defer os.Unsetenv(k)
+			}
+
+			o := &Options{Privileged: true, Env: []string{"DEBUG=1"}}
+			FromEnvironment()(o)
+
+			Expect(o.Repository).Should(Equal("mirror:5000/localstack"))
+			Expect(o.Tag).Should(Equal("0.12.5"))
+			Expect(o.Privileged).Should(BeFalse())
+			Expect(o.Reuse).Should(BeTrue())
+			Expect(o.Services).Should(Equal([]string{ServiceDynamoDB, ServiceSQS}))
+			Expect(o.Env).Should(ConsistOf("DEBUG=0", "OPTS=a=b", "SERVICES=dynamodb,sqs"))
+		})
+		It("should fail to start on invalid environment values", func() {
+			for _, key := range []string{EnvPrivileged, EnvReuse, EnvReadyTimeout} {
+				Expect(os.Setenv(key, "maybe")).Should(BeNil())
+
+				_, err := Start(context.Background(), FromEnvironment())
+				Expect(err).Should(MatchError(ContainSubstring("invalid " + key)))
+
+				Expect(os.Unsetenv(key)).Should(BeNil())
+			}
+		})
+	})
+	Context("Attach", func() {
+		It("should reject endpoints that are not URLs", func() {
+			for _, endpoint := range []string{"", "localhost:4566", "//localhost:4566", "http://"} {
+				_, err := Attach(context.Background(), endpoint)
+				Expect(err).Should(MatchError(ContainSubstring("invalid localstack endpoint")), endpoint)
+			}
+		})
+		It("should build edge and legacy endpoints from the attached URL", func() {
+			edge, err := AttachedEndpoints("https://localstack.example.com", EdgeEndpoints)
+			Expect(err).Should(BeNil())
+			Expect(edge.DynamoDB).Should(Equal("https://localstack.example.com"))
+			Expect(edge.Lambda).Should(Equal("https://localstack.example.com"))
+
+			edge, err = AttachedEndpoints("http://localstack:4566", EdgeEndpoints)
+			Expect(err).Should(BeNil())
+			Expect(edge.SQS).Should(Equal("http://localstack:4566"))
+
+			legacy, err := AttachedEndpoints("http://localstack:4566", LegacyEndpoints)
+			Expect(err).Should(BeNil())
+			Expect(legacy.DynamoDB).Should(Equal("http://localstack:4569"))
+			Expect(legacy.Lambda).Should(Equal("http://localstack:4574"))
+			Expect(legacy.APIGateway).Should(Equal("http://localstack:4566"))
+		})
+		It("should attach to the running localstack and leave it running on close", func() {
+			mode := LegacyEndpoints
+			if config.Endpoints.DynamoDB == config.Endpoints.APIGateway {
+				mode = EdgeEndpoints
+			}
+
+			// The generic lambda already exists, its ResourceConflictException is tolerated
+			stack, err := Attach(context.Background(), config.Endpoints.APIGateway, WithEndpointMode(mode))
+			Expect(err).Should(BeNil())
+			Expect(stack.Endpoints.DynamoDB).Should(Equal(config.Endpoints.DynamoDB))
+			Expect(stack.Close()).Should(BeNil())
+
+			_, err = services.LambdaClient().GetFunction(&lambda.GetFunctionInput{
+				FunctionName: aws.String(GenericEmptyLambda),
+			})
+			Expect(err).Should(BeNil())
+		})
+	})
+	Context("Dynamic ports", func() {
+		It("should start a second stack on host ports chosen by docker", func() {
+			stack, err := Start(context.Background(), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(stack.Close()).Should(BeNil())
+			}()
+
+			Expect(stack.Endpoints.DynamoDB).ShouldNot(Equal(config.Endpoints.DynamoDB))
+
+			_, err = stack.DynamoDbClient().ListTables(&dynamodb.ListTablesInput{})
+			Expect(err).Should(BeNil())
+		})
+	})
+	Context("Edge endpoints", func() {
+		It("should route every service through the edge port on newer images", func() {
+			stack, err := Start(context.Background(), WithTag("0.14.1"), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(stack.Close()).Should(BeNil())
+			}()
+
+			Expect(stack.Endpoints.DynamoDB).Should(Equal(stack.Endpoints.APIGateway))
+			Expect(stack.Endpoints.Lambda).Should(Equal(stack.Endpoints.APIGateway))
+			Expect(stack.Endpoints.S3).Should(Equal(stack.Endpoints.APIGateway))
+		})
+	})
+	Context("Container reuse", func() {
+		It("should reuse a labelled container across stacks", func() {
+			first, err := Start(context.Background(), WithReuse(), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			Expect(first.Close()).Should(BeNil())
+
+			second, err := Start(context.Background(), WithReuse(), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			Expect(second.Endpoints).Should(Equal(first.Endpoints))
+			Expect(second.ResourceName("testTable")).ShouldNot(Equal("testTable"))
+			Expect(second.Close()).Should(BeNil())
+
+			removed, err := ReapIdle(context.Background(), 0)
+			Expect(err).Should(BeNil())
+			Expect(removed).Should(HaveLen(1))
+		})
+		It("should only replace a reusable container once it stopped", func() {
+			first, err := Start(context.Background(), WithReuse(), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			Expect(first.Close()).Should(BeNil())
+
+			pool, err := dockertest.NewPool("")
+			Expect(err).Should(BeNil())
+			containers, err := pool.Client.ListContainers(dc.ListContainersOptions{
+				Filters: map[string][]string{"label": {LabelReuse + "=true"}},
+			})
+			Expect(err).Should(BeNil())
+			Expect(containers).Should(HaveLen(1))
+			Expect(pool.Client.StopContainer(containers[0].ID, 10)).Should(BeNil())
+
+			second, err := Start(context.Background(), WithReuse(), WithDynamicPorts())
+			Expect(err).Should(BeNil())
+			Expect(second.Close()).Should(BeNil())
+
+			removed, err := ReapIdle(context.Background(), 0)
+			Expect(err).Should(BeNil())
+			Expect(removed).Should(HaveLen(1))
+		})
+	})
+	Context("Readiness", func() {
+		It("should grow the delay between probes up to its maximum", func() {
+			backoff := Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+			Expect(backoff.Next(time.Second)).Should(Equal(2 * time.Second))
+			Expect(backoff.Next(4 * time.Second)).Should(Equal(5 * time.Second))
+
+			constant := Backoff{Initial: time.Second, Max: 5 * time.Second}
+			Expect(constant.Next(time.Second)).Should(Equal(time.Second))
+
+			uncapped := Backoff{Initial: time.Second, Multiplier: 2}
+			Expect(uncapped.Next(8 * time.Second)).Should(Equal(16 * time.Second))
+		})
+		It("should only wait on the requested services and report them", func() {
+			stack, err := Start(
+				context.Background(),
+				WithServices(ServiceDynamoDB, ServiceSQS),
+				WithDynamicPorts(),
+				WithReadyTimeout(2*time.Minute),
+			)
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(stack.Close()).Should(BeNil())
+			}()
+
+			report := stack.Report()
+			Expect(report.Services).Should(HaveLen(2))
+			for _, service := range report.Services {
+				Expect(service.Ready).Should(BeTrue())
+				Expect(service.Attempts).Should(BeNumerically(">=", 1))
+			}
+		})
+	})
+	Context("Logs", func() {
+		It("should stream and dump the container logs", func() {
+			streamed := &syncBuffer{}
+			stack, err := Start(context.Background(), WithDynamicPorts(), WithLogWriter(streamed))
+			Expect(err).Should(BeNil())
+			defer func() {
+				Expect(stack.Close()).Should(BeNil())
+			}()
+
+			Eventually(streamed.String, 30*time.Second).ShouldNot(BeEmpty())
+
+			logs, err := stack.Logs(context.Background())
+			Expect(err).Should(BeNil())
+			Expect(logs).ShouldNot(BeEmpty())
+
+			path, err := stack.DumpLogsOnFailure(true, "should stream and dump the container logs")
+			Expect(err).Should(BeNil())
+			Expect(path).Should(BeAnExistingFile())
+		})
+		It("should stop writing the streamed logs once closed", func() {
+			var mu sync.Mutex
+			var closed bool
+			var late int
+
+			stack, err := Start(context.Background(), WithDynamicPorts(), WithLogWriter(LogfWriter(
+				func(format string, args ...interface{}) {
+					mu.Lock()
+					defer mu.Unlock()
+					if closed {
+						late++
+					}
+				},
+			)))
+			Expect(err).Should(BeNil())
+			Expect(stack.Close()).Should(BeNil())
+
+			mu.Lock()
+			closed = true
+			mu.Unlock()
+
+			Consistently(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return late
+			}, time.Second).Should(BeZero())
+		})
+		It("should pass the incomplete last line once flushed", func() {
+			var lines []string
+			w := LogfWriter(func(format string, args ...interface{}) {
+				lines = append(lines, fmt.Sprintf(format, args...))
+			})
+
+			_, err := w.Write([]byte("first\r\nsec"))
+			Expect(err).Should(BeNil())
+			_, err = w.Write([]byte("ond\nlast"))
+			Expect(err).Should(BeNil())
+			Expect(lines).Should(Equal([]string{"first", "second"}))
+
+			Expect(w.(interface{ Flush() error }).Flush()).Should(BeNil())
+			Expect(lines).Should(Equal([]string{"first", "second", "last"}))
+		})
+	})
+})
+
+// syncBuffer is a buffer safe to read while a stack streams its logs to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
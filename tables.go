@@ -0,0 +1,366 @@
+package lokalstack
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+// defaultCapacity is the read and write capacity of provisioned tables and indexes
+const defaultCapacity = 2
+
+// keyAttribute is a key attribute of a table or an index
+type keyAttribute struct {
+	name string
+	typ  string
+}
+
+// TableSpec declares a DynamoDB table. The attribute definitions are inferred
+// from the keys of the table and its indexes.
+//
+//	NewTableSpec("orders").
+//		HashKey("customer", dynamodb.ScalarAttributeTypeS).
+//		RangeKey("created", dynamodb.ScalarAttributeTypeN).
+//		OnDemand().
+//		Stream(dynamodb.StreamViewTypeNewAndOldImages).
+//		GlobalIndex(NewIndexSpec("byStatus").HashKey("status", dynamodb.ScalarAttributeTypeS).ProjectKeysOnly()).
+//		Create(ctx)
+type TableSpec struct {
+	name       string
+	hash       *keyAttribute
+	rng        *keyAttribute
+	onDemand   bool
+	read       int64
+	write      int64
+	streamView string
+	sse        *dynamodb.SSESpecification
+	tags       []*dynamodb.Tag
+	global     []*IndexSpec
+	local      []*IndexSpec
+	ttl        *string
+}
+
+// NewTableSpec declares the named table, with provisioned capacity until OnDemand is called
+func NewTableSpec(name string) *TableSpec {
+	return &TableSpec{name: name, read: defaultCapacity, write: defaultCapacity}
+}
+
+// Name returns the name of the table
+func (t *TableSpec) Name() string {
+	return t.name
+}
+
+// HashKey sets the partition key, typ is one of the dynamodb.ScalarAttributeType values
+func (t *TableSpec) HashKey(name string, typ string) *TableSpec {
+	t.hash = &keyAttribute{name: name, typ: typ}
+	return t
+}
+
+// RangeKey sets the sort key, typ is one of the dynamodb.ScalarAttributeType values
+func (t *TableSpec) RangeKey(name string, typ string) *TableSpec {
+	t.rng = &keyAttribute{name: name, typ: typ}
+	return t
+}
+
+// OnDemand bills the table per request, without provisioned capacity
+func (t *TableSpec) OnDemand() *TableSpec {
+	t.onDemand = true
+	return t
+}
+
+// Provisioned sets the capacity of the table, and of the global indexes without their own
+func (t *TableSpec) Provisioned(read int64, write int64) *TableSpec {
+	t.onDemand = false
+	t.read, t.write = read, write
+	return t
+}
+
+// Stream enables the stream of the table, viewType is one of the dynamodb.StreamViewType values
+func (t *TableSpec) Stream(viewType string) *TableSpec {
+	t.streamView = viewType
+	return t
+}
+
+// Encrypted enables server side encryption with the given KMS key, or with the
+// AWS managed key when kmsKeyID is empty
+func (t *TableSpec) Encrypted(kmsKeyID string) *TableSpec {
+	t.sse = &dynamodb.SSESpecification{Enabled: aws.Bool(true)}
+	if kmsKeyID != "" {
+		t.sse.SSEType = aws.String(dynamodb.SSETypeKms)
+		t.sse.KMSMasterKeyId = aws.String(kmsKeyID)
+	}
+	return t
+}
+
+// Tag adds a tag to the table
+func (t *TableSpec) Tag(key string, value string) *TableSpec {
+	t.tags = append(t.tags, &dynamodb.Tag{Key: aws.String(key), Value: aws.String(value)})
+	return t
+}
+
+// TTL enables the time to live of the table on the given attribute
+func (t *TableSpec) TTL(attrName string) *TableSpec {
+	t.ttl = aws.String(attrName)
+	return t
+}
+
+// GlobalIndex adds a global secondary index
+func (t *TableSpec) GlobalIndex(index *IndexSpec) *TableSpec {
+	t.global = append(t.global, index)
+	return t
+}
+
+// LocalIndex adds a local secondary index, its hash key defaults to the one of the table
+func (t *TableSpec) LocalIndex(index *IndexSpec) *TableSpec {
+	t.local = append(t.local, index)
+	return t
+}
+
+// Input returns the CreateTableInput declared by the spec
+func (t *TableSpec) Input() (input *dynamodb.CreateTableInput, err error) {
+	if t.hash == nil {
+		return nil, errors.Errorf("table %s has no hash key", t.name)
+	}
+
+	defs := attributeDefinitions{}
+	input = &dynamodb.CreateTableInput{
+		TableName: aws.String(t.name),
+		KeySchema: defs.keySchema(t.hash, t.rng),
+	}
+
+	if t.onDemand {
+		input.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+	} else {
+		input.BillingMode = aws.String(dynamodb.BillingModeProvisioned)
+		input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(t.read),
+			WriteCapacityUnits: aws.Int64(t.write),
+		}
+	}
+
+	for _, index := range t.global {
+		if index.hash == nil {
+			return nil, errors.Errorf("global index %s of table %s has no hash key", index.name, t.name)
+		}
+		gsi := &dynamodb.GlobalSecondaryIndex{
+			IndexName:  aws.String(index.name),
+			KeySchema:  defs.keySchema(index.hash, index.rng),
+			Projection: index.projection(),
+		}
+		if !t.onDemand {
+			read, write := t.read, t.write
+			if index.read > 0 {
+				read, write = index.read, index.write
+			}
+			gsi.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(read),
+				WriteCapacityUnits: aws.Int64(write),
+			}
+		}
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, gsi)
+	}
+
+	for _, index := range t.local {
+		hash := index.hash
+		if hash == nil {
+			hash = t.hash
+		}
+		if hash.name != t.hash.name {
+			return nil, errors.Errorf("local index %s of table %s must use the hash key %s", index.name, t.name, t.hash.name)
+		}
+		if index.rng == nil {
+			return nil, errors.Errorf("local index %s of table %s has no range key", index.name, t.name)
+		}
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+			IndexName:  aws.String(index.name),
+			KeySchema:  defs.keySchema(hash, index.rng),
+			Projection: index.projection(),
+		})
+	}
+
+	if input.AttributeDefinitions, err = defs.list(); err != nil {
+		return nil, errors.Wrapf(err, "table %s", t.name)
+	}
+
+	if t.streamView != "" {
+		input.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(t.streamView),
+		}
+	}
+	input.SSESpecification = t.sse
+	input.Tags = t.tags
+
+	if err = input.Validate(); err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
+
+// Create creates the table declared by the spec
+func (t *TableSpec) Create(ctx context.Context) (err error) {
+	var input *dynamodb.CreateTableInput
+	if input, err = t.Input(); err == nil {
+		err = createTable(ctx, services.DynamoDbClient(), input, t.ttl)
+	}
+
+	return err
+}
+
+// IndexSpec declares a secondary index of a TableSpec
+type IndexSpec struct {
+	name           string
+	hash           *keyAttribute
+	rng            *keyAttribute
+	projectionType string
+	nonKey         []string
+	read           int64
+	write          int64
+}
+
+// NewIndexSpec declares the named index, projecting all attributes until another projection is set
+func NewIndexSpec(name string) *IndexSpec {
+	return &IndexSpec{name: name, projectionType: dynamodb.ProjectionTypeAll}
+}
+
+// HashKey sets the partition key of the index
+func (i *IndexSpec) HashKey(name string, typ string) *IndexSpec {
+	i.hash = &keyAttribute{name: name, typ: typ}
+	return i
+}
+
+// RangeKey sets the sort key of the index
+func (i *IndexSpec) RangeKey(name string, typ string) *IndexSpec {
+	i.rng = &keyAttribute{name: name, typ: typ}
+	return i
+}
+
+// ProjectAll projects every attribute into the index
+func (i *IndexSpec) ProjectAll() *IndexSpec {
+	i.projectionType, i.nonKey = dynamodb.ProjectionTypeAll, nil
+	return i
+}
+
+// ProjectKeysOnly projects the table and index keys into the index
+func (i *IndexSpec) ProjectKeysOnly() *IndexSpec {
+	i.projectionType, i.nonKey = dynamodb.ProjectionTypeKeysOnly, nil
+	return i
+}
+
+// ProjectInclude projects the keys and the given attributes into the index
+func (i *IndexSpec) ProjectInclude(attrNames ...string) *IndexSpec {
+	i.projectionType, i.nonKey = dynamodb.ProjectionTypeInclude, attrNames
+	return i
+}
+
+// Provisioned sets the capacity of a global index of a provisioned table
+func (i *IndexSpec) Provisioned(read int64, write int64) *IndexSpec {
+	i.read, i.write = read, write
+	return i
+}
+
+func (i *IndexSpec) projection() *dynamodb.Projection {
+	projection := &dynamodb.Projection{ProjectionType: aws.String(i.projectionType)}
+	if len(i.nonKey) > 0 {
+		projection.NonKeyAttributes = aws.StringSlice(i.nonKey)
+	}
+	return projection
+}
+
+// attributeDefinitions collects the types of the key attributes, by attribute name
+type attributeDefinitions map[string][]string
+
+// keySchema records the types of the keys and returns their key schema
+func (d attributeDefinitions) keySchema(hash *keyAttribute, rng *keyAttribute) []*dynamodb.KeySchemaElement {
+	d[hash.name] = append(d[hash.name], hash.typ)
+	if rng == nil {
+		return NewKeySchema(hash.name, nil)
+	}
+	d[rng.name] = append(d[rng.name], rng.typ)
+	return NewKeySchema(hash.name, aws.String(rng.name))
+}
+
+// list returns the definitions sorted by name, failing on attributes declared with several types
+func (d attributeDefinitions) list() (res []*dynamodb.AttributeDefinition, err error) {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		typ := d[name][0]
+		for _, other := range d[name][1:] {
+			if other != typ {
+				return nil, errors.Errorf("attribute %s declared as both %s and %s", name, typ, other)
+			}
+		}
+		res = append(res, NewAttributeDefinition(name, typ))
+	}
+
+	return res, nil
+}
+
+// createTable creates a table, waits for it to be active and enables its time to live
+func createTable(
+	ctx context.Context,
+	client dynamodbiface.DynamoDBAPI,
+	input *dynamodb.CreateTableInput,
+	ttlAttr *string,
+) (err error) {
+	logInfo("Creating table for testing", F("table", aws.StringValue(input.TableName)))
+
+	if _, err = client.CreateTableWithContext(ctx, input); err == nil {
+		err = waitForTable(ctx, client, aws.StringValue(input.TableName))
+	}
+
+	if err == nil && ttlAttr != nil {
+		err = AddTTL(ctx, aws.StringValue(input.TableName), *ttlAttr)
+	}
+
+	return err
+}
+
+// WaitForTable waits until the table and every global secondary index of it are active
+func WaitForTable(ctx context.Context, tableName string) error {
+	return waitForTable(ctx, services.DynamoDbClient(), tableName)
+}
+
+func waitForTable(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName string) error {
+	pending := "table " + tableName + ", status unknown"
+
+	err := poll(ctx, func(ctx context.Context) (bool, error) {
+		output, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			// Tables may be described shortly after they are created
+			pending = "table " + tableName + ", not found"
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if status := aws.StringValue(output.Table.TableStatus); status != dynamodb.TableStatusActive {
+			pending = "table " + tableName + ", status " + status
+			return false, nil
+		}
+		for _, index := range output.Table.GlobalSecondaryIndexes {
+			if status := aws.StringValue(index.IndexStatus); status != dynamodb.IndexStatusActive {
+				pending = "index " + aws.StringValue(index.IndexName) + " of table " + tableName + ", status " + status
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return errors.Wrapf(err, "waiting for %s", pending)
+}
@@ -0,0 +1,253 @@
+package lokalstack_test
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/kraneware/kws/services"
+	. "github.com/kraneware/lokalstack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DynamoDB Testing Services", func() {
+	Context("Table specs", func() {
+		It("should infer the attribute definitions from the keys", func() {
+			input, err := NewTableSpec("inferredTable").
+				HashKey("customer", dynamodb.ScalarAttributeTypeS).
+				RangeKey("created", dynamodb.ScalarAttributeTypeN).
+				GlobalIndex(NewIndexSpec("byStatus").HashKey("status", dynamodb.ScalarAttributeTypeS)).
+				LocalIndex(NewIndexSpec("byTotal").RangeKey("total", dynamodb.ScalarAttributeTypeN)).
+				Input()
+			Expect(err).Should(BeNil())
+			Expect(input.AttributeDefinitions).Should(Equal([]*dynamodb.AttributeDefinition{
+				NewAttributeDefinition("created", dynamodb.ScalarAttributeTypeN),
+				NewAttributeDefinition("customer", dynamodb.ScalarAttributeTypeS),
+				NewAttributeDefinition("status", dynamodb.ScalarAttributeTypeS),
+				NewAttributeDefinition("total", dynamodb.ScalarAttributeTypeN),
+			}))
+			Expect(input.LocalSecondaryIndexes[0].KeySchema[0].AttributeName).Should(Equal(aws.String("customer")))
+		})
+		It("should reject an attribute declared with several types", func() {
+			_, err := NewTableSpec("conflictingTable").
+				HashKey("id", dynamodb.ScalarAttributeTypeS).
+				GlobalIndex(NewIndexSpec("byId").HashKey("id", dynamodb.ScalarAttributeTypeN)).
+				Input()
+			Expect(err).Should(MatchError(ContainSubstring("attribute id declared as both S and N")))
+		})
+		It("should create an on-demand table with a stream and projections", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			Expect(NewTableSpec("onDemandTable").
+				HashKey("customer", dynamodb.ScalarAttributeTypeS).
+				RangeKey("created", dynamodb.ScalarAttributeTypeN).
+				OnDemand().
+				Stream(dynamodb.StreamViewTypeNewAndOldImages).
+				Tag("team", "testing").
+				GlobalIndex(NewIndexSpec("byStatus").
+					HashKey("status", dynamodb.ScalarAttributeTypeS).
+					ProjectInclude("total")).
+				Create(testCtx)).Should(BeNil())
+
+			output, err := services.DynamoDbClient().DescribeTableWithContext(
+				testCtx,
+				&dynamodb.DescribeTableInput{TableName: aws.String("onDemandTable")},
+			)
+			Expect(err).Should(BeNil())
+			Expect(output.Table.StreamSpecification.StreamViewType).Should(Equal(aws.String(dynamodb.StreamViewTypeNewAndOldImages)))
+			Expect(output.Table.GlobalSecondaryIndexes[0].Projection.ProjectionType).Should(Equal(aws.String(dynamodb.ProjectionTypeInclude)))
+		})
+	})
+	Context("Table status", func() {
+		It("should wait for a table and its indexes to be active", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			Expect(NewTableSpec("indexedTable").
+				HashKey("id", dynamodb.ScalarAttributeTypeS).
+				GlobalIndex(NewIndexSpec("byStatus").HashKey("status", dynamodb.ScalarAttributeTypeS)).
+				TTL("expires").
+				Create(testCtx)).Should(BeNil())
+			Expect(WaitForTable(testCtx, "indexedTable")).Should(BeNil())
+		})
+		It("should name the table still pending when the wait times out", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			ctx, cancel := context.WithTimeout(testCtx, 2*time.Second)
+			defer cancel()
+			Expect(WaitForTable(ctx, "missingTable")).Should(MatchError(ContainSubstring("table missingTable, not found")))
+		})
+	})
+	Context("Templates", func() {
+		It("should read the tables of a CloudFormation template", func() {
+			specs, err := TemplateTables("testdata/templates/tables.yaml", map[string]string{"Environment": "test"})
+			Expect(err).Should(BeNil())
+			Expect(specs).Should(HaveLen(2))
+
+			input, err := specs[0].Input()
+			Expect(err).Should(BeNil())
+			Expect(input.TableName).Should(Equal(aws.String("test-orders")))
+			Expect(input.BillingMode).Should(Equal(aws.String(dynamodb.BillingModePayPerRequest)))
+			Expect(input.GlobalSecondaryIndexes[0].IndexName).Should(Equal(aws.String("byStatus-test")))
+			Expect(input.LocalSecondaryIndexes[0].Projection.ProjectionType).Should(Equal(aws.String(dynamodb.ProjectionTypeKeysOnly)))
+			Expect(input.AttributeDefinitions).Should(ContainElement(NewAttributeDefinition("created", dynamodb.ScalarAttributeTypeN)))
+
+			Expect(specs[1].Name()).Should(Equal("SessionsTable"))
+		})
+		It("should resolve the short form functions and conditions of the tables", func() {
+			specs, err := TemplateTables("testdata/templates/intrinsics.yaml", map[string]string{"Env": "prod"})
+			Expect(err).Should(BeNil())
+			Expect(specs).Should(HaveLen(3))
+			Expect(specs[0].Name()).Should(Equal("prod-folded table"))
+			Expect(specs[1].Name()).Should(Equal("prod-literal"))
+			Expect(specs[2].Name()).Should(Equal("ProdOnly"))
+
+			input, err := specs[1].Input()
+			Expect(err).Should(BeNil())
+			Expect(input.BillingMode).Should(Equal(aws.String(dynamodb.BillingModeProvisioned)))
+			Expect(input.ProvisionedThroughput.ReadCapacityUnits).Should(Equal(aws.Int64(5)))
+			Expect(input.ProvisionedThroughput.WriteCapacityUnits).Should(Equal(aws.Int64(7)))
+			Expect(input.GlobalSecondaryIndexes).Should(HaveLen(1))
+			Expect(input.StreamSpecification.StreamViewType).Should(Equal(aws.String(dynamodb.StreamViewTypeNewImage)))
+			Expect(input.SSESpecification.KMSMasterKeyId).Should(BeNil())
+			Expect(input.Tags).Should(ContainElement(&dynamodb.Tag{Key: aws.String("env"), Value: aws.String("it's prod")}))
+
+			specs, err = TemplateTables("testdata/templates/intrinsics.yaml", nil)
+			Expect(err).Should(BeNil())
+			Expect(specs).Should(HaveLen(2))
+			Expect(specs[1].Name()).Should(Equal("dev-literal"))
+
+			input, err = specs[1].Input()
+			Expect(err).Should(BeNil())
+			Expect(input.BillingMode).Should(Equal(aws.String(dynamodb.BillingModePayPerRequest)))
+			Expect(input.ProvisionedThroughput).Should(BeNil())
+			Expect(input.GlobalSecondaryIndexes).Should(BeEmpty())
+			Expect(input.StreamSpecification).Should(BeNil())
+
+			_, err = TemplateTables("testdata/templates/intrinsics.yaml", map[string]string{"Env": "broken"})
+			Expect(err).Should(MatchError(ContainSubstring("resource Broken")))
+			Expect(err).Should(MatchError(ContainSubstring("unsupported intrinsic function Fn::GetAtt")))
+		})
+		It("should create the tables of a CloudFormation template", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			names, err := NewTablesFromTemplate(testCtx, "testdata/templates/tables.yaml", nil)
+			Expect(err).Should(BeNil())
+			Expect(names).Should(Equal([]string{"dev-orders", "SessionsTable"}))
+
+			output, err := services.DynamoDbClient().DescribeTimeToLiveWithContext(
+				testCtx,
+				&dynamodb.DescribeTimeToLiveInput{TableName: aws.String("dev-orders")},
+			)
+			Expect(err).Should(BeNil())
+			Expect(output.TimeToLiveDescription.AttributeName).Should(Equal(aws.String("expires")))
+		})
+	})
+	Context("Fixtures", func() {
+		It("should seed the tables of a fixture directory", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			Expect(NewTableSpec("orders").
+				HashKey("customer", dynamodb.ScalarAttributeTypeS).
+				RangeKey("created", dynamodb.ScalarAttributeTypeN).
+				Create(testCtx)).Should(BeNil())
+			Expect(NewTableSpec("sessions").
+				HashKey("session", dynamodb.ScalarAttributeTypeS).
+				Create(testCtx)).Should(BeNil())
+
+			Expect(SeedFixtures(testCtx, "testdata/fixtures/orders")).Should(BeNil())
+
+			output, err := services.DynamoDbClient().GetItemWithContext(testCtx, &dynamodb.GetItemInput{
+				TableName: aws.String("orders"),
+				Key: map[string]*dynamodb.AttributeValue{
+					"customer": {S: aws.String("bob")},
+					"created":  {N: aws.String("1650000100")},
+				},
+			})
+			Expect(err).Should(BeNil())
+			Expect(output.Item["total"].N).Should(Equal(aws.String("99999999999999999999.99")))
+
+			scan, err := services.DynamoDbClient().ScanWithContext(testCtx, &dynamodb.ScanInput{
+				TableName: aws.String("sessions"),
+			})
+			Expect(err).Should(BeNil())
+			Expect(scan.Count).Should(Equal(aws.Int64(2)))
+		})
+		It("should keep the numbers of YAML fixtures as written", func() {
+			items, err := ReadFixture("testdata/fixtures/yaml/plain.yaml")
+			Expect(err).Should(BeNil())
+			Expect(items).Should(HaveLen(1))
+			Expect(items[0]["total"].N).Should(Equal(aws.String("99999999999999999999.99")))
+			Expect(items[0]["count"].N).Should(Equal(aws.String("31")))
+			Expect(items[0]["gift"].BOOL).Should(Equal(aws.Bool(true)))
+			Expect(items[0]["note"].NULL).Should(Equal(aws.Bool(true)))
+
+			items, err = ReadFixture("testdata/fixtures/yaml/dynamodb.yaml")
+			Expect(err).Should(BeNil())
+			Expect(items).Should(HaveLen(1))
+			Expect(items[0]["expires"].N).Should(Equal(aws.String("1650003800")))
+			Expect(items[0]["scores"].NS).Should(Equal(aws.StringSlice([]string{"1", "2.5"})))
+		})
+	})
+	Context("Snapshots", func() {
+		It("should snapshot a table and diff it against its golden file", func() {
+			testCtx, td := NewTestDaemon()
+			defer td.Close()
+
+			Expect(NewTableSpec("snapshotTable").
+				HashKey("id", dynamodb.ScalarAttributeTypeS).
+				Create(testCtx)).Should(BeNil())
+			Expect(SeedTable(testCtx, "snapshotTable", "testdata/fixtures/snapshot.json")).Should(BeNil())
+
+			snapshot, err := SnapshotTable(testCtx, "snapshotTable")
+			Expect(err).Should(BeNil())
+			Expect(snapshot.Keys).Should(Equal([]string{"id"}))
+			Expect(snapshot).Should(MatchGoldenSnapshot("testdata/golden/snapshotTable.json"))
+
+			_, err = services.DynamoDbClient().PutItemWithContext(testCtx, &dynamodb.PutItemInput{
+				TableName: aws.String("snapshotTable"),
+				Item: map[string]*dynamodb.AttributeValue{
+					"id":      {S: aws.String("a")},
+					"version": {N: aws.String("3")},
+				},
+			})
+			Expect(err).Should(BeNil())
+
+			changed, err := SnapshotTable(testCtx, "snapshotTable")
+			Expect(err).Should(BeNil())
+			Expect(changed).ShouldNot(MatchSnapshot(snapshot))
+			Expect(changed.Diff(snapshot)).Should(ContainSubstring("version: 1 -> 3"))
+		})
+		It("should not keep the failure of an earlier match", func() {
+			expected := &TableSnapshot{Table: "t", Keys: []string{"id"}, Items: []map[string]interface{}{
+				{"id": "a", "version": "1"},
+			}}
+			changed := &TableSnapshot{Table: "t", Keys: []string{"id"}, Items: []map[string]interface{}{
+				{"id": "a", "version": "3"},
+			}}
+
+			matcher := MatchSnapshot(expected)
+			Expect(matcher.Match(changed)).Should(BeFalse())
+			Expect(matcher.Match(expected)).Should(BeTrue())
+		})
+		It("should compare numbers by value", func() {
+			expected := &TableSnapshot{Table: "t", Keys: []string{"id"}, Items: []map[string]interface{}{
+				{"id": json.Number("1.0"), "total": json.Number("12.50"), "lines": []interface{}{json.Number("2.00")}},
+			}}
+			actual := &TableSnapshot{Table: "t", Keys: []string{"id"}, Items: []map[string]interface{}{
+				{"id": json.Number("1"), "total": json.Number("12.5"), "lines": []interface{}{json.Number("2")}},
+			}}
+
+			Expect(actual).Should(MatchSnapshot(expected))
+			Expect(actual.Diff(expected)).Should(BeEmpty())
+		})
+	})
+})
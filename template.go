@@ -0,0 +1,786 @@
+package lokalstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	cfnDynamoDBTable = "AWS::DynamoDB::Table"
+	samSimpleTable   = "AWS::Serverless::SimpleTable"
+)
+
+// NewTablesFromTemplate creates the AWS::DynamoDB::Table and AWS::Serverless::SimpleTable
+// resources of a CloudFormation or SAM template, in YAML or JSON, and returns the
+// names of the created tables. The parameters override the template parameter defaults.
+func NewTablesFromTemplate(ctx context.Context, path string, params map[string]string) (names []string, err error) {
+	var specs []*TableSpec
+	if specs, err = TemplateTables(path, params); err != nil {
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		if err = spec.Create(ctx); err != nil {
+			return names, errors.Wrapf(err, "creating table %s of %s", spec.Name(), path)
+		}
+		names = append(names, spec.Name())
+	}
+
+	return names, nil
+}
+
+// TemplateTables returns the specs of the table resources of a CloudFormation or SAM
+// template, ordered by logical id. Tables without a TableName are named after
+// their logical id, tables whose Condition is false are left out. Ref, Fn::Sub,
+// Fn::Join and Fn::If are resolved against the parameters, the template parameter
+// defaults, the conditions and the AWS::Region, AWS::AccountId, AWS::StackName and
+// AWS::NoValue pseudo parameters. Only the properties read by the tables are
+// resolved, the other functions of a template, e.g. Fn::GetAtt, may appear elsewhere.
+func TemplateTables(path string, params map[string]string) (specs []*TableSpec, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(path); err != nil {
+		return nil, errors.Wrap(err, "reading template")
+	}
+
+	var doc yaml.Node
+	var value interface{}
+	var raw []byte
+	if err = yaml.Unmarshal(contents, &doc); err == nil {
+		if value, err = templateValue(&doc); err == nil {
+			raw, err = json.Marshal(value)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing template %s", path)
+	}
+
+	var template struct {
+		Parameters map[string]struct {
+			Default interface{}
+		}
+		Conditions map[string]interface{}
+		Resources  map[string]struct {
+			Type       string
+			Condition  string
+			Properties interface{}
+		}
+	}
+	if err = json.Unmarshal(raw, &template); err != nil {
+		return nil, errors.Wrapf(err, "parsing template %s", path)
+	}
+
+	r := &templateResolver{
+		params: map[string]string{
+			"AWS::Region":    currentRegion(),
+			"AWS::AccountId": emulatorAccount,
+			"AWS::StackName": "lokalstack",
+		},
+		conditions: template.Conditions,
+		evaluating: map[string]bool{},
+	}
+	for name, param := range template.Parameters {
+		if param.Default != nil {
+			r.params[name] = fmt.Sprint(param.Default)
+		}
+	}
+	for name, value := range params {
+		r.params[name] = value
+	}
+
+	logicalIDs := make([]string, 0, len(template.Resources))
+	for logicalID := range template.Resources {
+		logicalIDs = append(logicalIDs, logicalID)
+	}
+	sort.Strings(logicalIDs)
+
+	for _, logicalID := range logicalIDs {
+		resource := template.Resources[logicalID]
+		if resource.Type != cfnDynamoDBTable && resource.Type != samSimpleTable {
+			continue
+		}
+
+		create := true
+		if resource.Condition != "" {
+			create, err = r.condition(resource.Condition)
+		}
+
+		var spec *TableSpec
+		var structure interface{}
+		var properties []byte
+		if err == nil && create {
+			if structure, err = r.structure(resource.Properties); err == nil {
+				properties, err = json.Marshal(structure)
+			}
+			if err == nil {
+				if resource.Type == cfnDynamoDBTable {
+					spec, err = cfnTableSpec(logicalID, properties, r)
+				} else {
+					spec, err = samTableSpec(logicalID, properties, r)
+				}
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "resource %s of %s", logicalID, path)
+		}
+
+		if spec == nil {
+			logInfo("Skipping table of a false condition", F("resource", logicalID), F("condition", resource.Condition))
+			continue
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// cfnValue is a scalar template value read by the table loaders. It is resolved
+// once decoded, so that the functions of the properties the loaders ignore never
+// are. The functions shaping the properties are resolved before, see structure.
+type cfnValue struct {
+	raw   interface{}
+	value string
+}
+
+func (v *cfnValue) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &v.raw)
+}
+
+func (v cfnValue) String() string {
+	return v.value
+}
+
+func (v cfnValue) int64() (int64, error) {
+	return strconv.ParseInt(v.value, 10, 64)
+}
+
+func (v cfnValue) bool() bool {
+	b, _ := strconv.ParseBool(v.value)
+	return b
+}
+
+// cfnValueType is the type of the values resolved by resolveValues
+var cfnValueType = reflect.TypeOf(cfnValue{}) // nolint:gochecknoglobals
+
+type cfnKeySchema []struct {
+	AttributeName cfnValue
+	KeyType       cfnValue
+}
+
+type cfnProjection struct {
+	ProjectionType   cfnValue
+	NonKeyAttributes []cfnValue
+}
+
+type cfnThroughput struct {
+	ReadCapacityUnits  cfnValue
+	WriteCapacityUnits cfnValue
+}
+
+func (t *cfnThroughput) values() (read int64, write int64, err error) {
+	if read, err = t.ReadCapacityUnits.int64(); err == nil {
+		write, err = t.WriteCapacityUnits.int64()
+	}
+	return read, write, errors.Wrap(err, "invalid ProvisionedThroughput")
+}
+
+type cfnSSE struct {
+	SSEEnabled cfnValue
+	// The key of the stack, e.g. !GetAtt Key.Arn, is unknown to localstack
+	KMSMasterKeyID cfnValue `json:"KMSMasterKeyId" cfn:"optional"`
+}
+
+type cfnIndex struct {
+	IndexName             cfnValue
+	KeySchema             cfnKeySchema
+	Projection            *cfnProjection
+	ProvisionedThroughput *cfnThroughput
+}
+
+// cfnTableSpec converts the properties of an AWS::DynamoDB::Table resource
+func cfnTableSpec(logicalID string, properties []byte, r *templateResolver) (spec *TableSpec, err error) {
+	var table struct {
+		TableName            cfnValue
+		AttributeDefinitions []struct {
+			AttributeName cfnValue
+			AttributeType cfnValue
+		}
+		KeySchema              cfnKeySchema
+		BillingMode            cfnValue
+		ProvisionedThroughput  *cfnThroughput
+		GlobalSecondaryIndexes []cfnIndex
+		LocalSecondaryIndexes  []cfnIndex
+		StreamSpecification    *struct {
+			StreamViewType cfnValue
+		}
+		SSESpecification *cfnSSE
+		Tags             []struct {
+			Key   cfnValue
+			Value cfnValue `cfn:"optional"`
+		}
+		TimeToLiveSpecification *struct {
+			AttributeName cfnValue
+			Enabled       cfnValue
+		}
+	}
+	if err = json.Unmarshal(properties, &table); err != nil {
+		return nil, err
+	}
+	if err = r.resolveValues(reflect.ValueOf(&table), ""); err != nil {
+		return nil, err
+	}
+
+	name := table.TableName.String()
+	if name == "" {
+		name = logicalID
+	}
+	spec = NewTableSpec(name)
+
+	types := map[string]string{}
+	for _, def := range table.AttributeDefinitions {
+		types[def.AttributeName.String()] = def.AttributeType.String()
+	}
+	if spec.hash, spec.rng, err = cfnKeys(table.KeySchema, types); err != nil {
+		return nil, err
+	}
+
+	if table.BillingMode.String() == dynamodb.BillingModePayPerRequest {
+		spec.OnDemand()
+	} else if table.ProvisionedThroughput != nil {
+		var read, write int64
+		if read, write, err = table.ProvisionedThroughput.values(); err != nil {
+			return nil, err
+		}
+		spec.Provisioned(read, write)
+	}
+
+	for _, index := range table.GlobalSecondaryIndexes {
+		var idx *IndexSpec
+		if idx, err = cfnIndexSpec(index, types); err != nil {
+			return nil, err
+		}
+		spec.GlobalIndex(idx)
+	}
+	for _, index := range table.LocalSecondaryIndexes {
+		var idx *IndexSpec
+		if idx, err = cfnIndexSpec(index, types); err != nil {
+			return nil, err
+		}
+		spec.LocalIndex(idx)
+	}
+
+	if table.StreamSpecification != nil && table.StreamSpecification.StreamViewType.String() != "" {
+		spec.Stream(table.StreamSpecification.StreamViewType.String())
+	}
+	if table.SSESpecification != nil && table.SSESpecification.SSEEnabled.bool() {
+		spec.Encrypted(table.SSESpecification.KMSMasterKeyID.String())
+	}
+	for _, tag := range table.Tags {
+		spec.Tag(tag.Key.String(), tag.Value.String())
+	}
+	if ttl := table.TimeToLiveSpecification; ttl != nil && ttl.Enabled.bool() {
+		spec.TTL(ttl.AttributeName.String())
+	}
+
+	return spec, nil
+}
+
+// cfnKeys returns the keys of a key schema, typed by the attribute definitions
+func cfnKeys(schema cfnKeySchema, types map[string]string) (hash *keyAttribute, rng *keyAttribute, err error) {
+	for _, element := range schema {
+		name := element.AttributeName.String()
+		typ, ok := types[name]
+		if !ok {
+			return nil, nil, errors.Errorf("key attribute %s has no attribute definition", name)
+		}
+		key := &keyAttribute{name: name, typ: typ}
+		switch element.KeyType.String() {
+		case dynamodb.KeyTypeHash:
+			hash = key
+		case dynamodb.KeyTypeRange:
+			rng = key
+		default:
+			return nil, nil, errors.Errorf("invalid KeyType %q of key attribute %s", element.KeyType, name)
+		}
+	}
+	return hash, rng, nil
+}
+
+// cfnIndexSpec converts a secondary index of an AWS::DynamoDB::Table resource
+func cfnIndexSpec(index cfnIndex, types map[string]string) (spec *IndexSpec, err error) {
+	name := index.IndexName.String()
+	spec = NewIndexSpec(name)
+
+	if spec.hash, spec.rng, err = cfnKeys(index.KeySchema, types); err != nil {
+		return nil, errors.Wrapf(err, "index %s", name)
+	}
+
+	if index.Projection != nil {
+		switch index.Projection.ProjectionType.String() {
+		case dynamodb.ProjectionTypeKeysOnly:
+			spec.ProjectKeysOnly()
+		case dynamodb.ProjectionTypeInclude:
+			attributes := make([]string, len(index.Projection.NonKeyAttributes))
+			for i, attribute := range index.Projection.NonKeyAttributes {
+				attributes[i] = attribute.String()
+			}
+			spec.ProjectInclude(attributes...)
+		}
+	}
+
+	if index.ProvisionedThroughput != nil {
+		var read, write int64
+		if read, write, err = index.ProvisionedThroughput.values(); err != nil {
+			return nil, errors.Wrapf(err, "index %s", name)
+		}
+		spec.Provisioned(read, write)
+	}
+
+	return spec, nil
+}
+
+// samAttributeTypes maps the SAM primary key types to DynamoDB attribute types
+var samAttributeTypes = map[string]string{ // nolint:gochecknoglobals
+	"String": dynamodb.ScalarAttributeTypeS,
+	"Number": dynamodb.ScalarAttributeTypeN,
+	"Binary": dynamodb.ScalarAttributeTypeB,
+}
+
+// samTableSpec converts the properties of an AWS::Serverless::SimpleTable resource,
+// billed per request unless it sets a ProvisionedThroughput
+func samTableSpec(logicalID string, properties []byte, r *templateResolver) (spec *TableSpec, err error) {
+	var table struct {
+		TableName  cfnValue
+		PrimaryKey *struct {
+			Name cfnValue
+			Type cfnValue
+		}
+		ProvisionedThroughput *cfnThroughput
+		SSESpecification      *cfnSSE
+		Tags                  map[string]cfnValue `cfn:"optional"`
+	}
+	if err = json.Unmarshal(properties, &table); err != nil {
+		return nil, err
+	}
+	if err = r.resolveValues(reflect.ValueOf(&table), ""); err != nil {
+		return nil, err
+	}
+
+	name := table.TableName.String()
+	if name == "" {
+		name = logicalID
+	}
+	spec = NewTableSpec(name)
+
+	if table.PrimaryKey == nil {
+		spec.HashKey("id", dynamodb.ScalarAttributeTypeS)
+	} else if typ, ok := samAttributeTypes[table.PrimaryKey.Type.String()]; ok {
+		spec.HashKey(table.PrimaryKey.Name.String(), typ)
+	} else {
+		return nil, errors.Errorf("invalid PrimaryKey type %q", table.PrimaryKey.Type)
+	}
+
+	if table.ProvisionedThroughput == nil {
+		spec.OnDemand()
+	} else {
+		var read, write int64
+		if read, write, err = table.ProvisionedThroughput.values(); err != nil {
+			return nil, err
+		}
+		spec.Provisioned(read, write)
+	}
+
+	if table.SSESpecification != nil && table.SSESpecification.SSEEnabled.bool() {
+		spec.Encrypted(table.SSESpecification.KMSMasterKeyID.String())
+	}
+
+	keys := make([]string, 0, len(table.Tags))
+	for key := range table.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		spec.Tag(key, table.Tags[key].String())
+	}
+
+	return spec, nil
+}
+
+// templateValue converts a YAML node to strings, lists and maps. Scalars are kept
+// as written, since YAML 1.1 reads N, the number attribute type, as false, and the
+// short form of the intrinsic functions, e.g. !Ref Table, becomes their long form,
+// e.g. {"Ref": "Table"}.
+func templateValue(node *yaml.Node) (value interface{}, err error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			return templateValue(node.Content[0])
+		}
+	case yaml.AliasNode:
+		return templateValue(node.Alias)
+	case yaml.ScalarNode:
+		if node.Tag != "!!null" {
+			value = node.Value
+		}
+	case yaml.SequenceNode:
+		l := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			if l[i], err = templateValue(item); err != nil {
+				return nil, err
+			}
+		}
+		value = l
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if m[node.Content[i].Value], err = templateValue(node.Content[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		value = m
+	}
+
+	if fn := intrinsicFunction(node.Tag); fn != "" {
+		value = map[string]interface{}{fn: value}
+	}
+
+	return value, nil
+}
+
+// intrinsicFunction returns the long form of a short form function tag, e.g.
+// Fn::Sub for !Sub, or an empty string for the other tags
+func intrinsicFunction(tag string) string {
+	if !strings.HasPrefix(tag, "!") || strings.HasPrefix(tag, "!!") {
+		return ""
+	}
+
+	switch name := tag[1:]; name {
+	case "Ref", "Condition":
+		return name
+	default:
+		return "Fn::" + name
+	}
+}
+
+// templateResolver resolves the intrinsic functions and conditions of template values
+type templateResolver struct {
+	params     map[string]string
+	conditions map[string]interface{}
+	evaluating map[string]bool
+}
+
+// subVariable matches the ${Name} variables of Fn::Sub, ${!Name} is a literal
+var subVariable = regexp.MustCompile(`\$\{([^}]*)\}`) // nolint:gochecknoglobals
+
+// structure resolves the Fn::If and Ref AWS::NoValue functions shaping a template
+// value, dropping the map entries and list items without a value, so that the
+// properties can be decoded before their scalar functions are resolved
+func (r *templateResolver) structure(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if arg, ok := v["Fn::If"]; ok && len(v) == 1 {
+			l, ok := arg.([]interface{})
+			if !ok || len(l) != 3 {
+				return nil, errors.Errorf("invalid Fn::If argument %v", arg)
+			}
+			ok, err := r.condition(fmt.Sprint(l[0]))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return r.structure(l[1])
+			}
+			return r.structure(l[2])
+		}
+		if ref, ok := v["Ref"]; ok && len(v) == 1 {
+			if ref == "AWS::NoValue" {
+				return nil, nil
+			}
+			return v, nil
+		}
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			resolved, err := r.structure(value)
+			if err != nil {
+				return nil, errors.Wrap(err, key)
+			}
+			if resolved != nil {
+				m[key] = resolved
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for i, value := range v {
+			resolved, err := r.structure(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "[%d]", i)
+			}
+			if resolved != nil {
+				l = append(l, resolved)
+			}
+		}
+		return l, nil
+	default:
+		return v, nil
+	}
+}
+
+// resolveValues resolves the cfnValue fields of a decoded resource in place, path
+// names the field in errors. A field tagged cfn:"optional" is left empty when it
+// cannot be resolved.
+func (r *templateResolver) resolveValues(v reflect.Value, path string) (err error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			err = r.resolveValues(v.Elem(), path)
+		}
+	case reflect.Struct:
+		if v.Type() == cfnValueType {
+			return r.resolveValue(v.Addr().Interface().(*cfnValue), path)
+		}
+		for i := 0; i < v.NumField() && err == nil; i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			name = strings.TrimPrefix(path+"."+name, ".")
+			if err = r.resolveValues(v.Field(i), name); err != nil && field.Tag.Get("cfn") == "optional" {
+				logWarn("Ignoring unresolved template value", F("error", err))
+				err = nil
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len() && err == nil; i++ {
+			err = r.resolveValues(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			if err = r.resolveValues(value, path+"."+key.String()); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	}
+
+	return err
+}
+
+// resolveValue resolves a value to a string
+func (r *templateResolver) resolveValue(v *cfnValue, path string) error {
+	resolved, err := r.resolve(v.raw)
+	if err != nil {
+		return errors.Wrap(err, path)
+	}
+
+	switch resolved := resolved.(type) {
+	case nil:
+		v.value = ""
+	case string:
+		v.value = resolved
+	default:
+		return errors.Errorf("%s: expected a string, got %v", path, resolved)
+	}
+
+	return nil
+}
+
+// resolve resolves the Ref, Fn::Sub, Fn::Join and Fn::If functions of a template value
+func (r *templateResolver) resolve(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for fn, arg := range v {
+				if fn == "Ref" || strings.HasPrefix(fn, "Fn::") {
+					return r.function(fn, arg)
+				}
+			}
+		}
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			resolved, err := r.resolve(value)
+			if err != nil {
+				return nil, errors.Wrap(err, key)
+			}
+			m[key] = resolved
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			resolved, err := r.resolve(value)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = resolved
+		}
+		return l, nil
+	default:
+		return v, nil
+	}
+}
+
+// function resolves an intrinsic function
+func (r *templateResolver) function(fn string, arg interface{}) (interface{}, error) {
+	if fn == "Fn::If" {
+		// Only the branch selected by the condition is resolved
+		l, ok := arg.([]interface{})
+		if !ok || len(l) != 3 {
+			return nil, errors.Errorf("invalid Fn::If argument %v", arg)
+		}
+		ok, err := r.condition(fmt.Sprint(l[0]))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return r.resolve(l[1])
+		}
+		return r.resolve(l[2])
+	}
+
+	arg, err := r.resolve(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fn {
+	case "Ref":
+		if arg == "AWS::NoValue" {
+			return nil, nil
+		}
+		return r.param(fmt.Sprint(arg))
+	case "Fn::Sub":
+		vars := map[string]interface{}{}
+		if l, ok := arg.([]interface{}); ok && len(l) == 2 {
+			arg = l[0]
+			vars, _ = l[1].(map[string]interface{})
+		}
+		s, ok := arg.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid Fn::Sub argument %v", arg)
+		}
+		var subErr error
+		res := subVariable.ReplaceAllStringFunc(s, func(match string) string {
+			name := match[2 : len(match)-1]
+			if strings.HasPrefix(name, "!") {
+				return "${" + name[1:] + "}"
+			}
+			if v, ok := vars[name]; ok {
+				return fmt.Sprint(v)
+			}
+			v, err := r.param(name)
+			if err != nil && subErr == nil {
+				subErr = err
+			}
+			return v
+		})
+		return res, subErr
+	case "Fn::Join":
+		l, ok := arg.([]interface{})
+		if !ok || len(l) != 2 {
+			return nil, errors.Errorf("invalid Fn::Join argument %v", arg)
+		}
+		values, ok := l[1].([]interface{})
+		if !ok {
+			return nil, errors.Errorf("invalid Fn::Join values %v", l[1])
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = fmt.Sprint(v)
+		}
+		return strings.Join(parts, fmt.Sprint(l[0])), nil
+	default:
+		return nil, errors.Errorf("unsupported intrinsic function %s", fn)
+	}
+}
+
+func (r *templateResolver) param(name string) (string, error) {
+	if v, ok := r.params[name]; ok {
+		return v, nil
+	}
+	return "", errors.Errorf("unresolved parameter %s", name)
+}
+
+// condition evaluates the named condition of the template
+func (r *templateResolver) condition(name string) (bool, error) {
+	def, ok := r.conditions[name]
+	if !ok {
+		return false, errors.Errorf("undefined condition %s", name)
+	}
+	if r.evaluating[name] {
+		return false, errors.Errorf("condition %s depends on itself", name)
+	}
+
+	r.evaluating[name] = true
+	defer delete(r.evaluating, name)
+
+	res, err := r.evaluate(def)
+	return res, errors.Wrapf(err, "condition %s", name)
+}
+
+// evaluate evaluates the Fn::Equals, Fn::Not, Fn::And, Fn::Or and Condition
+// functions of a condition
+func (r *templateResolver) evaluate(value interface{}) (bool, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false, errors.Errorf("invalid condition %v", value)
+	}
+
+	for fn, arg := range m {
+		if fn == "Condition" {
+			return r.condition(fmt.Sprint(arg))
+		}
+
+		args, ok := arg.([]interface{})
+		if !ok {
+			return false, errors.Errorf("invalid %s argument %v", fn, arg)
+		}
+
+		switch fn {
+		case "Fn::Equals":
+			if len(args) != 2 {
+				return false, errors.Errorf("invalid Fn::Equals argument %v", arg)
+			}
+			values, err := r.resolve(args)
+			if err != nil {
+				return false, err
+			}
+			l := values.([]interface{})
+			return fmt.Sprint(l[0]) == fmt.Sprint(l[1]), nil
+		case "Fn::Not":
+			if len(args) != 1 {
+				return false, errors.Errorf("invalid Fn::Not argument %v", arg)
+			}
+			res, err := r.evaluate(args[0])
+			return !res, err
+		case "Fn::And", "Fn::Or":
+			for _, cond := range args {
+				res, err := r.evaluate(cond)
+				if err != nil {
+					return false, err
+				}
+				if res != (fn == "Fn::And") {
+					return res, nil
+				}
+			}
+			return fn == "Fn::And", nil
+		}
+
+		return false, errors.Errorf("unsupported condition function %s", fn)
+	}
+
+	return false, nil
+}
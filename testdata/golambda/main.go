@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type event struct {
+	Name string `json:"name"`
+}
+
+func handler(ctx context.Context, e event) (map[string]string, error) {
+	return map[string]string{
+		"greeting": os.Getenv("GREETING") + " " + e.Name,
+	}, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,5 @@
+from helpers import greet
+
+
+def handler(event, context):
+    return {"greeting": greet(event.get("name", "world"))}
@@ -0,0 +1,160 @@
+package lokalstack
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/kraneware/kws/services"
+	"github.com/pkg/errors"
+)
+
+// WaitForLambdaUpdate waits until the function is no longer pending or being updated,
+// and fails if its last update failed. Localstack versions not reporting these
+// states are considered settled.
+func WaitForLambdaUpdate(ctx context.Context, functionName string) error {
+	var state, status string
+
+	err := poll(ctx, func(ctx context.Context) (bool, error) {
+		output, err := services.LambdaClient().GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+			FunctionName: aws.String(functionName),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		state, status = aws.StringValue(output.State), aws.StringValue(output.LastUpdateStatus)
+		if status == lambda.LastUpdateStatusFailed {
+			return false, errors.Errorf("update failed: %s", aws.StringValue(output.LastUpdateStatusReason))
+		}
+
+		return state != lambda.StatePending && status != lambda.LastUpdateStatusInProgress, nil
+	})
+
+	return errors.Wrapf(err, "waiting for lambda function %s, state %q, last update status %q", functionName, state, status)
+}
+
+// UpdateLambdaCode replaces the code of the function with the zip archive, as built
+// by ZipFiles, ZipFS or ZipDir, and waits for the update to settle
+func UpdateLambdaCode(ctx context.Context, functionName string, zipFile []byte) (err error) {
+	logInfo("Updating lambda function code", F("function", functionName))
+
+	if _, err = services.LambdaClient().UpdateFunctionCodeWithContext(ctx, &lambda.UpdateFunctionCodeInput{
+		FunctionName: aws.String(functionName),
+		ZipFile:      zipFile,
+	}); err == nil {
+		err = WaitForLambdaUpdate(ctx, functionName)
+	}
+
+	return err
+}
+
+// UpdateLambdaConfig applies the configuration set by the options, e.g. WithLambdaEnv
+// or WithLambdaTimeout, to the function and waits for the update to settle
+func UpdateLambdaConfig(ctx context.Context, functionName string, opts ...LambdaOption) (err error) {
+	logInfo("Updating lambda function configuration", F("function", functionName))
+
+	config := &lambda.CreateFunctionInput{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.Runtime != nil && !contains(lambda.Runtime_Values(), *config.Runtime) {
+		return errors.Errorf("lambda function %s: unsupported runtime %q", functionName, *config.Runtime)
+	}
+
+	if _, err = services.LambdaClient().UpdateFunctionConfigurationWithContext(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName:      aws.String(functionName),
+		DeadLetterConfig:  config.DeadLetterConfig,
+		Description:       config.Description,
+		Environment:       config.Environment,
+		EphemeralStorage:  config.EphemeralStorage,
+		FileSystemConfigs: config.FileSystemConfigs,
+		Handler:           config.Handler,
+		ImageConfig:       config.ImageConfig,
+		KMSKeyArn:         config.KMSKeyArn,
+		Layers:            config.Layers,
+		MemorySize:        config.MemorySize,
+		Role:              config.Role,
+		Runtime:           config.Runtime,
+		Timeout:           config.Timeout,
+		TracingConfig:     config.TracingConfig,
+		VpcConfig:         config.VpcConfig,
+	}); err == nil {
+		err = WaitForLambdaUpdate(ctx, functionName)
+	}
+
+	return err
+}
+
+// PublishVersion publishes the current code and configuration of the function as a
+// new version, once pending updates settled, and returns the version
+func PublishVersion(ctx context.Context, functionName string, description string) (version string, err error) {
+	if err = WaitForLambdaUpdate(ctx, functionName); err == nil {
+		var output *lambda.FunctionConfiguration
+		if output, err = services.LambdaClient().PublishVersionWithContext(ctx, &lambda.PublishVersionInput{
+			FunctionName: aws.String(functionName),
+			Description:  aws.String(description),
+		}); err == nil {
+			version = aws.StringValue(output.Version)
+			logInfo("Published lambda function version", F("function", functionName), F("version", version))
+		}
+	}
+
+	return version, err
+}
+
+// routingConfig routes the given share, between 0 and 1, of the invocations to each additional version
+func routingConfig(weights map[string]float64) *lambda.AliasRoutingConfiguration {
+	if len(weights) == 0 {
+		return nil
+	}
+	return &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: aws.Float64Map(weights)}
+}
+
+// CreateAlias points the alias at the version of the function. The weights route a
+// share, between 0 and 1, of the invocations to additional versions.
+func CreateAlias(
+	ctx context.Context,
+	functionName string,
+	alias string,
+	version string,
+	weights map[string]float64,
+) (err error) {
+	logInfo("Creating lambda function alias", F("function", functionName), F("alias", alias), F("version", version))
+
+	_, err = services.LambdaClient().CreateAliasWithContext(ctx, &lambda.CreateAliasInput{
+		FunctionName:    aws.String(functionName),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(version),
+		RoutingConfig:   routingConfig(weights),
+	})
+
+	return err
+}
+
+// UpdateAlias points the alias at the version of the function, replacing its weights.
+// An empty weights map routes every invocation to the version.
+func UpdateAlias(
+	ctx context.Context,
+	functionName string,
+	alias string,
+	version string,
+	weights map[string]float64,
+) (err error) {
+	logInfo("Updating lambda function alias", F("function", functionName), F("alias", alias), F("version", version))
+
+	routing := routingConfig(weights)
+	if routing == nil {
+		routing = &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]*float64{}}
+	}
+
+	_, err = services.LambdaClient().UpdateAliasWithContext(ctx, &lambda.UpdateAliasInput{
+		FunctionName:    aws.String(functionName),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(version),
+		RoutingConfig:   routing,
+	})
+
+	return err
+}
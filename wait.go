@@ -0,0 +1,39 @@
+package lokalstack
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultWaitTimeout bounds the waits on resources whose context has no deadline
+const DefaultWaitTimeout = 2 * time.Minute
+
+// pollInterval is the delay between two checks of a resource state
+const pollInterval = 500 * time.Millisecond
+
+// poll calls check until it reports done or fails. It gives up with the context
+// error once the context is done, or after DefaultWaitTimeout if the context has
+// no deadline.
+func poll(ctx context.Context, check func(ctx context.Context) (done bool, err error)) (err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultWaitTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		var done bool
+		if done, err = check(ctx); done || err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}